@@ -0,0 +1,148 @@
+// billingcheck 在本地或 CI 中执行用量检查和报表任务，不需要部署 Cloud Function
+//
+//	billingcheck [flags] check daily|week|month
+//	billingcheck [flags] report week|month
+//	billingcheck [flags] notify test
+//	billingcheck [flags] config validate
+//	billingcheck [flags] serve
+package main
+
+import (
+	billingUsage "clzrt.io/billingUsage"
+	"clzrt.io/billingUsage/internal"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+const usage = `usage: billingcheck [flags] <command> <subcommand>
+
+commands:
+  check daily|week|month   检查日/周/月用量异常并发送钉钉消息
+  report week|month        生成周/月报表并发送邮件
+  notify test              发送一条钉钉测试消息
+  config validate          校验配置文件
+  serve                    启动 HTTP 服务，监听 -addr 或环境变量 PORT，设置环境变量 BILLINGCHECK_TOKEN 时需要 Bearer token
+
+flags:
+`
+
+// jobs 命令对应的任务
+var jobs = map[string]map[string]string{
+	"check": {
+		"daily": internal.JobDailyCheck,
+		"week":  internal.JobWeekCheck,
+		"month": internal.JobMonthCheck,
+	},
+	"report": {
+		"week":  internal.JobWeekReport,
+		"month": internal.JobMonthReport,
+	},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run 解析参数并执行命令，返回进程退出码
+func run(args []string) int {
+	var cmd billingUsage.RunCommand
+	flags := flag.NewFlagSet("billingcheck", flag.ContinueOnError)
+	flags.StringVar(&cmd.ConfigPath, "config", "config_bk.yaml", "配置文件路径")
+	flags.StringVar(&cmd.AsOf, "as-of", "", "运行日期 YYYY-MM-DD，默认为今天")
+	flags.StringVar(&cmd.OutputDir, "output", "", "报表和预算状态保存的本地目录，不设置时使用配置中的 Cloud Storage")
+	flags.BoolVar(&cmd.DryRun, "dry-run", false, "只查询和检查，不发送通知和邮件，不保存报表")
+	flags.StringVar(&cmd.RenderDir, "render-dir", "", "dry run 时将钉钉消息、邮件和报表写入该目录，隐含 -dry-run")
+	addr := flags.String("addr", defaultAddr(), "serve 监听的地址")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+
+	// 参数可以在命令前或命令后
+	var words []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				return 0
+			}
+			return 2
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			break
+		}
+		words = append(words, args[0])
+		args = args[1:]
+	}
+	if cmd.RenderDir != "" {
+		cmd.DryRun = true
+	}
+	if len(words) == 1 && words[0] == "serve" {
+		log.Printf("listening on %s", *addr)
+		// token 通过环境变量传入，不出现在进程参数中
+		token := os.Getenv("BILLINGCHECK_TOKEN")
+		if token == "" {
+			log.Printf("BILLINGCHECK_TOKEN is not set, requests are not authenticated and cannot set recipients or profile")
+		}
+		if err := http.ListenAndServe(*addr, billingUsage.NewHandler(cmd, token)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	if len(words) != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	ctx := context.Background()
+	command, sub := words[0], words[1]
+	switch {
+	case command == "config" && sub == "validate":
+		if err := billingUsage.ValidateConfig(cmd.ConfigPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("%s is valid\n", cmd.ConfigPath)
+		return 0
+	case command == "notify" && sub == "test":
+		if err := billingUsage.SendTestNotification(ctx, cmd); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	job, ok := jobs[command][sub]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s %s\n", command, sub)
+		flags.Usage()
+		return 2
+	}
+	cmd.Jobs = []string{job}
+	result := billingUsage.Run(ctx, cmd)
+	summary, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(string(summary))
+	if err := result.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// defaultAddr Cloud Run 通过环境变量 PORT 指定端口
+func defaultAddr() string {
+	if port := os.Getenv("PORT"); port != "" {
+		return ":" + port
+	}
+	return ":8080"
+}
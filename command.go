@@ -0,0 +1,108 @@
+package billingUsage
+
+import (
+	"clzrt.io/billingUsage/internal"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// RunCommand Pub/Sub 消息 data 中的 JSON 命令，同一个部署可以由多个 Cloud Scheduler 任务以不同的命令触发
+// 例如 {"jobs": ["weekReport"], "asOf": "2024-03-11", "recipients": ["finance@example.com"]}
+// data 为空时按 schedule 执行到期的任务
+type RunCommand struct {
+	// Jobs 要执行的任务，为空时执行 schedule 中到期的任务
+	Jobs []string `json:"jobs"`
+	// AsOf 运行日期 YYYY-MM-DD，按该日期计算统计周期和报表文件名
+	AsOf string `json:"asOf"`
+	// DryRun 只执行查询和检查，不发送通知和邮件，不保存报表和预算报警状态
+	DryRun bool `json:"dryRun"`
+	// Recipients 覆盖配置中的邮件收件人
+	Recipients []string `json:"recipients"`
+	// Profile 使用 config_<profile>.yaml，默认为 bk 即 config_bk.yaml
+	Profile string `json:"profile"`
+	// BackfillFrom/BackfillTo 重新生成该日期范围内每天的日/周/月报表，不发送通知和邮件
+	BackfillFrom string `json:"backfillFrom"`
+	BackfillTo   string `json:"backfillTo"`
+	// ConfigPath 配置文件路径，不为空时忽略 Profile，只用于命令行
+	ConfigPath string `json:"-"`
+	// OutputDir 报表和预算状态保存在本地目录，不使用 Cloud Storage，只用于命令行
+	OutputDir string `json:"-"`
+	// RenderDir DryRun 时将钉钉消息、邮件和报表写入该目录而不是跳过，只用于命令行和 HTTP 服务
+	RenderDir string `json:"-"`
+}
+
+const defaultProfile = "bk"
+
+var profilePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// parseRunCommand 解析并校验命令，data 为空时返回默认命令
+func parseRunCommand(data []byte) (RunCommand, error) {
+	var cmd RunCommand
+	if len(data) == 0 {
+		return cmd, nil
+	}
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return cmd, fmt.Errorf("invalid run command: %v", err)
+	}
+	for _, job := range cmd.Jobs {
+		if !internal.IsJob(job) {
+			return cmd, fmt.Errorf("unknown job %q, expected one of %v", job, internal.Jobs)
+		}
+	}
+	if cmd.Profile != "" && !profilePattern.MatchString(cmd.Profile) {
+		return cmd, fmt.Errorf("invalid config profile %q", cmd.Profile)
+	}
+	dates := []struct{ name, value string }{{"asOf", cmd.AsOf}, {"backfillFrom", cmd.BackfillFrom}, {"backfillTo", cmd.BackfillTo}}
+	for _, date := range dates {
+		if _, err := time.Parse("2006-01-02", date.value); date.value != "" && err != nil {
+			return cmd, fmt.Errorf("invalid %s %q, expected YYYY-MM-DD", date.name, date.value)
+		}
+	}
+	if (cmd.BackfillFrom == "") != (cmd.BackfillTo == "") {
+		return cmd, fmt.Errorf("backfill requires both backfillFrom and backfillTo")
+	}
+	if cmd.BackfillFrom != "" && (cmd.AsOf != "" || len(cmd.Jobs) > 0) {
+		return cmd, fmt.Errorf("backfill cannot be combined with asOf or jobs")
+	}
+	return cmd, nil
+}
+
+// render dry run 时是否将通知、邮件和报表写入 RenderDir
+func (c RunCommand) render() bool {
+	return c.DryRun && c.RenderDir != ""
+}
+
+// renderDir 各渠道写入请求的目录，不写入时为空
+func (c RunCommand) renderDir() string {
+	if c.render() {
+		return c.RenderDir
+	}
+	return ""
+}
+
+// withProfile 使用 profile 对应配置的副本，设置了 ConfigPath 时为其所在目录下的 config_<profile>.yaml
+func (c RunCommand) withProfile(profile string) RunCommand {
+	if profile == "" {
+		return c
+	}
+	c.Profile = profile
+	if c.ConfigPath != "" {
+		c.ConfigPath = filepath.Join(filepath.Dir(c.ConfigPath), "config_"+profile+".yaml")
+	}
+	return c
+}
+
+// configPath 命令使用的配置文件
+func (c RunCommand) configPath() string {
+	if c.ConfigPath != "" {
+		return c.ConfigPath
+	}
+	profile := c.Profile
+	if profile == "" {
+		profile = defaultProfile
+	}
+	return "config_" + profile + ".yaml"
+}
@@ -0,0 +1,45 @@
+package billingUsage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRunCommand(t *testing.T) {
+	cmd, err := parseRunCommand(nil)
+	require.NoError(t, err)
+	assert.Equal(t, RunCommand{}, cmd)
+	assert.Equal(t, "config_bk.yaml", cmd.configPath())
+
+	cmd, err = parseRunCommand([]byte(`{"jobs": ["weekReport", "monthReport"], "asOf": "2024-03-11", "dryRun": true, "recipients": ["finance@example.com"], "profile": "prod"}`))
+	require.NoError(t, err)
+	assert.Equal(t, RunCommand{
+		Jobs:       []string{"weekReport", "monthReport"},
+		AsOf:       "2024-03-11",
+		DryRun:     true,
+		Recipients: []string{"finance@example.com"},
+		Profile:    "prod",
+	}, cmd)
+	assert.Equal(t, "config_prod.yaml", cmd.configPath())
+	assert.Equal(t, "config_prod.yaml", RunCommand{}.withProfile("prod").configPath())
+	assert.Equal(t, filepath.Join("deploy", "config_prod.yaml"), RunCommand{ConfigPath: "deploy/config.yaml"}.withProfile("prod").configPath())
+	assert.Equal(t, "deploy/config.yaml", RunCommand{ConfigPath: "deploy/config.yaml"}.withProfile("").configPath())
+
+	invalid := []string{
+		`weekReport`,
+		`{"jobs": ["weeklyReport"]}`,
+		`{"profile": "../secrets"}`,
+		`{"backfillFrom": "2024-03-01"}`,
+		`{"backfillFrom": "2024-03-01", "backfillTo": "2024-03-05", "jobs": ["weekReport"]}`,
+		`{"asOf": "20240311"}`,
+		`{"asOf": "2024-02-30"}`,
+		`{"backfillFrom": "2024-03-01", "backfillTo": "March 5"}`,
+	}
+	for _, data := range invalid {
+		_, err := parseRunCommand([]byte(data))
+		assert.Error(t, err, data)
+	}
+}
@@ -0,0 +1,201 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	baselineStdDev = "stddev"
+	baselineMAD    = "mad"
+
+	// madScale 使 MAD 在正态分布下与标准差可比
+	madScale = 1.4826
+
+	// 未配置时的 minSpend 和 minDeviation，与示例配置一致
+	defaultBaselineMinSpend     = 15
+	defaultBaselineMinDeviation = 10
+
+	// baselineMinSpreadRatio 离散程度的下限占预期费用的比例，历史几乎不变时 MAD/标准差为 0，避免任何波动都报警
+	baselineMinSpreadRatio = 0.05
+)
+
+// BaselineAnomaly 偏离滚动基线的项目日费用
+type BaselineAnomaly struct {
+	ProjectID   string
+	ProjectName string
+	Day         time.Time
+	Actual      float64
+	Expected    float64
+	Lower       float64
+	Upper       float64
+	Currency    string
+}
+
+// BaselineDetector 将某天的费用与过去 N 天的滚动基线比较，避免周末、节假日前后的误报
+type BaselineDetector struct {
+	source   BillingSource
+	cfg      config.Baseline
+	calendar *Calendar
+}
+
+// NewBaselineDetector calendar 用于确定"昨天"，为 nil 时使用本地时区
+func NewBaselineDetector(source BillingSource, cfg config.Baseline, calendar *Calendar) (*BaselineDetector, error) {
+	if calendar == nil {
+		calendar, _ = NewCalendar("", "")
+	}
+	if cfg.WindowDays == 0 {
+		cfg.WindowDays = 28
+	}
+	if cfg.K == 0 {
+		cfg.K = 3
+	}
+	if cfg.MinSamples == 0 {
+		cfg.MinSamples = 3
+	}
+	if cfg.MinSpend == 0 {
+		cfg.MinSpend = defaultBaselineMinSpend
+	}
+	if cfg.MinDeviation == 0 {
+		cfg.MinDeviation = defaultBaselineMinDeviation
+	}
+	cfg.Method = strings.ToLower(cfg.Method)
+	if cfg.Method == "" {
+		cfg.Method = baselineMAD
+	}
+	if cfg.Method != baselineMAD && cfg.Method != baselineStdDev {
+		return nil, fmt.Errorf("invalid baseline method %q, expected mad/stddev", cfg.Method)
+	}
+	if cfg.WindowDays < 0 || cfg.K < 0 || cfg.MinSamples < 0 || cfg.MinSpend < 0 || cfg.MinDeviation < 0 {
+		return nil, fmt.Errorf("baseline windowDays, k, minSamples, minSpend and minDeviation must be positive")
+	}
+	return &BaselineDetector{source: source, cfg: cfg, calendar: calendar}, nil
+}
+
+// Check 检查昨天的费用，与 DailyCheck 的本期相同
+func (d *BaselineDetector) Check(ctx context.Context) ([]BaselineAnomaly, error) {
+	return d.CheckDay(ctx, d.calendar.DailyWindow(d.calendar.Now()).CurStart)
+}
+
+// CheckDay 检查 day 当天的费用，day 为当天零点
+func (d *BaselineDetector) CheckDay(ctx context.Context, day time.Time) ([]BaselineAnomaly, error) {
+	start := day.AddDate(0, 0, -d.cfg.WindowDays)
+	costs, err := d.source.DailyCosts(ctx, start, day.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	return detectBaseline(costs, day, d.cfg), nil
+}
+
+// detectBaseline 按项目计算基线，缺失的日期视为费用 0
+func detectBaseline(costs []ProjectDailyCost, day time.Time, cfg config.Baseline) []BaselineAnomaly {
+	type history struct {
+		name     string
+		currency string
+		byDay    map[time.Time]float64
+	}
+	projects := make(map[string]*history)
+	var order []string
+	for _, c := range costs {
+		h, ok := projects[c.ProjectID]
+		if !ok {
+			h = &history{byDay: make(map[time.Time]float64)}
+			projects[c.ProjectID] = h
+			order = append(order, c.ProjectID)
+		}
+		if h.name == "" {
+			h.name = c.ProjectName
+		}
+		if h.currency == "" {
+			h.currency = c.Currency
+		}
+		h.byDay[c.Day] += c.Cost
+	}
+
+	var res []BaselineAnomaly
+	for _, projectID := range order {
+		h := projects[projectID]
+		var samples []float64
+		for i := 1; i <= cfg.WindowDays; i++ {
+			d := day.AddDate(0, 0, -i)
+			if cfg.Seasonality && d.Weekday() != day.Weekday() {
+				continue
+			}
+			samples = append(samples, h.byDay[d])
+		}
+		if len(samples) < cfg.MinSamples {
+			continue
+		}
+
+		var expected, spread float64
+		if cfg.Method == baselineStdDev {
+			expected, spread = meanStdDev(samples)
+		} else {
+			expected = median(samples)
+			spread = mad(samples, expected) * madScale
+		}
+		spread = math.Max(spread, math.Abs(expected)*baselineMinSpreadRatio)
+
+		actual := h.byDay[day]
+		lower, upper := expected-cfg.K*spread, expected+cfg.K*spread
+		if actual < cfg.MinSpend && expected < cfg.MinSpend {
+			continue
+		}
+		if actual >= lower && actual <= upper {
+			continue
+		}
+		if math.Abs(actual-expected) < cfg.MinDeviation {
+			continue
+		}
+		res = append(res, BaselineAnomaly{
+			ProjectID:   projectID,
+			ProjectName: h.name,
+			Day:         day,
+			Actual:      actual,
+			Expected:    expected,
+			Lower:       lower,
+			Upper:       upper,
+			Currency:    h.currency,
+		})
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return math.Abs(res[i].Actual-res[i].Expected) > math.Abs(res[j].Actual-res[j].Expected)
+	})
+	return res
+}
+
+func meanStdDev(values []float64) (mean, stdDev float64) {
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	for _, v := range values {
+		stdDev += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(stdDev / float64(len(values)))
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// mad 中位数绝对偏差
+func mad(values []float64, center float64) float64 {
+	deviations := make([]float64, len(values))
+	for i, v := range values {
+		deviations[i] = math.Abs(v - center)
+	}
+	return median(deviations)
+}
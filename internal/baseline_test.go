@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dailyHistory 生成 day 之前 days 天的费用，cost 按星期几返回
+func dailyHistory(projectID string, day time.Time, days int, cost func(d time.Time) float64) []ProjectDailyCost {
+	var res []ProjectDailyCost
+	for i := days; i >= 0; i-- {
+		d := day.AddDate(0, 0, -i)
+		res = append(res, ProjectDailyCost{ProjectID: projectID, Day: d, Cost: cost(d), Currency: "USD"})
+	}
+	return res
+}
+
+func TestDetectBaseline(t *testing.T) {
+	// 2024-03-11 为周一
+	monday := time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)
+	weekly := func(weekday, weekend float64) func(d time.Time) float64 {
+		return func(d time.Time) float64 {
+			if d.Weekday() == time.Saturday || d.Weekday() == time.Sunday {
+				return weekend
+			}
+			return weekday
+		}
+	}
+	withActual := func(costs []ProjectDailyCost, actual float64) []ProjectDailyCost {
+		costs[len(costs)-1].Cost = actual
+		return costs
+	}
+	noisy := func(d time.Time) float64 { return 100 + float64(d.Day()%5) }
+
+	tests := []struct {
+		name     string
+		cfg      config.Baseline
+		costs    []ProjectDailyCost
+		expected []float64
+	}{
+		{
+			name:  "weekday after weekend is normal with seasonality",
+			cfg:   config.Baseline{Seasonality: true},
+			costs: dailyHistory("p", monday, 28, weekly(100, 10)),
+		},
+		{
+			name:     "weekday after weekend misfires without seasonality",
+			cfg:      config.Baseline{WindowDays: 3},
+			costs:    dailyHistory("p", monday, 3, weekly(100, 10)),
+			expected: []float64{10},
+		},
+		{
+			name:     "spike over mad band",
+			cfg:      config.Baseline{Seasonality: true},
+			costs:    withActual(dailyHistory("p", monday, 28, weekly(100, 10)), 300),
+			expected: []float64{100},
+		},
+		{
+			name:  "noise within stddev band",
+			cfg:   config.Baseline{Method: "stddev"},
+			costs: dailyHistory("p", monday, 28, noisy),
+		},
+		{
+			name:     "spike over stddev band",
+			cfg:      config.Baseline{Method: "stddev"},
+			costs:    withActual(dailyHistory("p", monday, 28, noisy), 130),
+			expected: []float64{102.107},
+		},
+		{
+			name:  "not enough samples",
+			cfg:   config.Baseline{Seasonality: true, WindowDays: 14},
+			costs: withActual(dailyHistory("p", monday, 14, weekly(100, 10)), 300),
+		},
+		{
+			name:  "below spend floor",
+			cfg:   config.Baseline{MinSpend: 500},
+			costs: withActual(dailyHistory("p", monday, 28, weekly(100, 100)), 300),
+		},
+		{
+			name:  "below minimum deviation",
+			cfg:   config.Baseline{MinDeviation: 5},
+			costs: withActual(dailyHistory("p", monday, 28, weekly(100, 100)), 102),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detector, err := NewBaselineDetector(&MemorySource{Days: tt.costs}, tt.cfg, nil)
+			require.NoError(t, err)
+			res, err := detector.CheckDay(context.Background(), monday)
+			require.NoError(t, err)
+
+			var expected []float64
+			for _, r := range res {
+				assert.Equal(t, monday, r.Day)
+				assert.True(t, r.Actual < r.Lower || r.Actual > r.Upper)
+				expected = append(expected, r.Expected)
+			}
+			assert.InDeltaSlice(t, tt.expected, expected, 0.01)
+		})
+	}
+}
+
+func TestNewBaselineDetector(t *testing.T) {
+	detector, err := NewBaselineDetector(&MemorySource{}, config.Baseline{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, config.Baseline{WindowDays: 28, Method: "mad", K: 3, MinSamples: 3, MinSpend: 15, MinDeviation: 10}, detector.cfg)
+
+	_, err = NewBaselineDetector(&MemorySource{}, config.Baseline{Method: "ewma"}, nil)
+	assert.Error(t, err)
+	_, err = NewBaselineDetector(&MemorySource{}, config.Baseline{MinDeviation: -1}, nil)
+	assert.Error(t, err)
+
+	// 历史完全平稳时 MAD 为 0，使用默认值和离散程度下限，小幅波动不报警
+	monday := time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)
+	flat := dailyHistory("web", monday, 28, func(d time.Time) float64 { return 1000 })
+	flat[len(flat)-1].Cost = 1000.5
+	detector, err = NewBaselineDetector(&MemorySource{}, config.Baseline{}, nil)
+	require.NoError(t, err)
+	assert.Empty(t, detectBaseline(flat, monday, detector.cfg))
+	flat[len(flat)-1].Cost = 1100
+	assert.Empty(t, detectBaseline(flat, monday, detector.cfg))
+	flat[len(flat)-1].Cost = 1200
+	assert.Len(t, detectBaseline(flat, monday, detector.cfg), 1)
+}
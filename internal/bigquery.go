@@ -4,166 +4,192 @@ import (
 	"cloud.google.com/go/bigquery"
 	"clzrt.io/billingUsage/internal/config"
 	"context"
+	"fmt"
 	"google.golang.org/api/iterator"
 	"log"
-	"math"
 	"time"
 )
 
 type BigQueryUserCase struct {
 	Client *bigquery.Client
 	Config *config.Config
+	// Clock 统计周期的当前时间，默认为系统时间
+	Clock Clock
 }
 
-func NewBigQueryUserCase(projectID string, ctx context.Context) *BigQueryUserCase {
-
+// NewBigQueryUserCase cfg 提供账单表和报表配置
+func NewBigQueryUserCase(projectID string, ctx context.Context, cfg *config.Config, clock Clock) (*BigQueryUserCase, error) {
 	client, err := bigquery.NewClient(ctx, projectID)
 	if err != nil {
-		log.Println(err)
+		return nil, fmt.Errorf("error creating BigQuery client: %v", err)
 	}
-	config, err := config.LoadConfig("config_bk.yaml")
-	return &BigQueryUserCase{client, config}
+	return &BigQueryUserCase{client, cfg, clock}, nil
 }
-func (u *BigQueryUserCase) WeekUsage(ctx context.Context) ([][]bigquery.Value, error) {
-	//第一天为周日
-	last, cur, next := getFirstWeekDay()
-	lastWeekRange := GetWeekRange(last, cur)
-	curWeekRange := GetWeekRange(cur, next)
 
-	log.Println("LastWeekRange: [" + lastWeekRange + "] CurWeekRange: [" + curWeekRange + "]")
-	q := u.Client.Query(
-		"SELECT project_id, lastWeek_cost, curWeek_cost, curWeek_cost - lastWeek_cost AS cost_difference " +
-			"FROM ( " +
-			"   SELECT project.id AS project_id, " +
-			"       SUM(CASE WHEN TIMESTAMP_TRUNC(_PARTITIONTIME, WEEK) = TIMESTAMP(\"" + last + "\") THEN cost ELSE 0 END) - " +
-			"       SUM(CASE WHEN TIMESTAMP_TRUNC(_PARTITIONTIME, DAY) = TIMESTAMP(\"" + last + "\") THEN cost ELSE 0 END) + " +
-			"       SUM(CASE WHEN TIMESTAMP_TRUNC(_PARTITIONTIME, DAY) = TIMESTAMP(\"" + cur + "\") THEN cost ELSE 0 END) AS lastWeek_cost, " +
-			"       SUM(CASE WHEN TIMESTAMP_TRUNC(_PARTITIONTIME, WEEK) = TIMESTAMP(\"" + cur + "\") THEN cost ELSE 0 END) - " +
-			"       SUM(CASE WHEN TIMESTAMP_TRUNC(_PARTITIONTIME, DAY) = TIMESTAMP(\"" + next + "\") THEN cost ELSE 0 END) + " +
-			"       SUM(CASE WHEN TIMESTAMP_TRUNC(_PARTITIONTIME, DAY) = TIMESTAMP(\"" + next + "\") THEN cost ELSE 0 END) AS curWeek_cost " +
-			"   FROM  `" +
-			u.Config.BigQuery.TableID + "`" +
-			"   WHERE TIMESTAMP_TRUNC(_PARTITIONTIME, WEEK) IN (TIMESTAMP(\"" + last + "\"), TIMESTAMP(\"" + cur + "\"), TIMESTAMP(\"" + next + "\")) " +
-			"   GROUP BY project.id " +
-			") AS costs " +
-			"ORDER BY cost_difference DESC ")
+// WithClock 返回按 clock 计算统计周期的副本，共用同一个 client
+func (u *BigQueryUserCase) WithClock(clock Clock) *BigQueryUserCase {
+	copied := *u
+	copied.Clock = clock
+	return &copied
+}
+func (u *BigQueryUserCase) WeekUsage(ctx context.Context) ([]ProjectCostComparison, error) {
+	calendar, err := u.calendar()
+	if err != nil {
+		return nil, err
+	}
+	w := calendar.WeekWindow(calendar.Now())
+	log.Printf("Week: [%s]", w)
+	return u.comparisons(ctx, func(b *QueryBuilder) UsageQuery { return b.WeekQuery(w) })
+}
 
-	rows, err := u.getValues(ctx, q)
+func (u *BigQueryUserCase) MonthUsage(ctx context.Context) ([]ProjectCostComparison, error) {
+	calendar, err := u.calendar()
+	if err != nil {
+		return nil, err
+	}
+	w := calendar.MonthWindow(calendar.Now())
+	log.Printf("Month: lastMonth: %s curMonth: %s", w.PrevStart.Format("2006-01"), w.CurStart.Format("2006-01"))
+	return u.comparisons(ctx, func(b *QueryBuilder) UsageQuery { return b.MonthQuery(w) })
+}
 
+func (u *BigQueryUserCase) InvoiceMonthUsage(ctx context.Context) ([]ProjectCostComparison, error) {
+	calendar, err := u.calendar()
 	if err != nil {
 		return nil, err
 	}
-	return rows, nil
+	w := calendar.MonthWindow(calendar.Now())
+	log.Printf("Invoice month: previous: %s current: %s", invoiceMonth(w.PrevStart), invoiceMonth(w.CurStart))
+	return u.comparisons(ctx, func(b *QueryBuilder) UsageQuery { return b.InvoiceMonthQuery(w) })
+}
 
+func (u *BigQueryUserCase) DailyUsage(ctx context.Context) ([]ProjectCostComparison, error) {
+	calendar, err := u.calendar()
+	if err != nil {
+		return nil, err
+	}
+	w := calendar.DailyWindow(calendar.Now())
+	log.Printf("Day: [%s]", w)
+	return u.comparisons(ctx, func(b *QueryBuilder) UsageQuery { return b.DailyQuery(w) })
 }
-func (u *BigQueryUserCase) WeekCheck(ctx context.Context) ([][]bigquery.Value, error) {
-	rows, err := u.WeekUsage(ctx)
+
+// comparisons 执行 query 生成的用量对比查询
+func (u *BigQueryUserCase) comparisons(ctx context.Context, query func(b *QueryBuilder) UsageQuery) ([]ProjectCostComparison, error) {
+	builder, err := u.queryBuilder()
 	if err != nil {
 		return nil, err
 	}
+	return u.getComparisons(ctx, u.query(query(builder)), builder.costMode == costModeNet)
+}
 
-	var res [][]bigquery.Value
-	for idx, row := range rows {
-		rowLen := len(rows[idx])
-		if usageChange, ok := row[rowLen-1].(float64); ok {
-			if lastUsage, ok2 := row[1].(float64); ok2 {
-				if math.Abs(usageChange) > lastUsage*0.3 || usageChange > 500 {
-					res = append(res, row)
-				}
-			}
+func (u *BigQueryUserCase) DailyCosts(ctx context.Context, start, end time.Time) ([]ProjectDailyCost, error) {
+	builder, err := u.queryBuilder()
+	if err != nil {
+		return nil, err
+	}
+	it, err := u.read(ctx, u.query(builder.DailyCostQuery(start, end)))
+	if err != nil {
+		return nil, err
+	}
+	var costs []ProjectDailyCost
+	for {
+		var row dailyCostRow
+		err := it.Next(&row)
+		if err == iterator.Done {
+			break
 		}
-
+		if err != nil {
+			return nil, err
+		}
+		labels, err := decodeLabels(row.ProjectLabels)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding labels of project %s: %v", row.ProjectID, err)
+		}
+		costs = append(costs, ProjectDailyCost{
+			ProjectID:     row.ProjectID,
+			ProjectName:   row.ProjectName,
+			Day:           row.Day.UTC(),
+			Cost:          row.Cost,
+			Currency:      row.Currency,
+			ProjectLabels: labels,
+		})
 	}
-	return res, nil
-
+	return costs, nil
 }
-func (u *BigQueryUserCase) MonthUsage(ctx context.Context) ([][]bigquery.Value, error) {
-	last, cur := getFirstMonthDay()
-	lastMonth := last[:7]
-	curMonth := cur[:7]
 
-	log.Print("Month: " + "\n" + "lastMonth: " + lastMonth + " curMonth: " + curMonth)
-	q := u.Client.Query(
-		"SELECT project.id AS project_id, " +
-			"SUM(CASE WHEN TIMESTAMP_TRUNC(_PARTITIONTIME, MONTH) = TIMESTAMP(\"" + last + "\") THEN cost ELSE 0 END) AS lastMonth_cost, " +
-			"SUM(CASE WHEN TIMESTAMP_TRUNC(_PARTITIONTIME, MONTH) = TIMESTAMP(\"" + cur + "\") THEN cost ELSE 0 END) AS curMonth_cost, " +
-			"SUM(CASE WHEN TIMESTAMP_TRUNC(_PARTITIONTIME, MONTH) = TIMESTAMP(\"" + cur + "\") THEN cost ELSE 0 END) -" +
-			"SUM(CASE WHEN TIMESTAMP_TRUNC(_PARTITIONTIME, MONTH) = TIMESTAMP(\"" + last + "\") THEN cost ELSE 0 END) AS cost_difference" +
-			" FROM  `" +
-			u.Config.BigQuery.TableID + "`" +
-			" WHERE  TIMESTAMP_TRUNC(_PARTITIONTIME, MONTH) IN (TIMESTAMP(\"" + last + "\"), TIMESTAMP(\"" + cur + "\")) " +
-			" GROUP BY project.id " +
-			" ORDER BY  cost_difference DESC ")
-	rows, err := u.getValues(ctx, q)
+func (u *BigQueryUserCase) CostBreakdown(ctx context.Context, w Window, projectIDs []string) ([]CostBreakdown, error) {
+	if len(projectIDs) == 0 {
+		return nil, nil
+	}
+	builder, err := u.queryBuilder()
 	if err != nil {
 		return nil, err
 	}
-	return rows, nil
-
-}
-func (u *BigQueryUserCase) MonthCheck(ctx context.Context) ([][]bigquery.Value, error) {
-	rows, err := u.MonthUsage(ctx)
+	it, err := u.read(ctx, u.query(builder.CostBreakdownQuery(w, projectIDs)))
 	if err != nil {
 		return nil, err
 	}
-
-	var res [][]bigquery.Value
-	for idx, row := range rows {
-		rowLen := len(rows[idx])
-		if usageChange, ok := row[rowLen-1].(float64); ok {
-			if lastUsage, ok2 := row[1].(float64); ok2 {
-				if math.Abs(usageChange) > lastUsage*0.3 {
-					res = append(res, row)
-				}
-			}
+	var res []CostBreakdown
+	for {
+		var row breakdownRow
+		err := it.Next(&row)
+		if err == iterator.Done {
+			break
 		}
-
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, CostBreakdown{
+			ProjectID:    row.ProjectID,
+			Service:      row.Service,
+			SKU:          row.SKU,
+			PreviousCost: row.PreviousCost,
+			CurrentCost:  row.CurrentCost,
+			Delta:        row.CurrentCost - row.PreviousCost,
+		})
 	}
 	return res, nil
-
 }
-func (u *BigQueryUserCase) DailyUsage(ctx context.Context) ([][]bigquery.Value, error) {
-	yesterday, today := getTodayAndYesterday()
-	log.Println("Day: \n" + "Yesterday: " + yesterday + " today: " + today)
-	q := u.Client.Query(
-		"SELECT project.id AS project_id, " +
-			"SUM(CASE WHEN TIMESTAMP_TRUNC(_PARTITIONTIME, DAY) = TIMESTAMP(\"" + yesterday + "\") THEN cost ELSE 0 END) AS lastDay_cost, " +
-			"SUM(CASE WHEN TIMESTAMP_TRUNC(_PARTITIONTIME, DAY) = TIMESTAMP(\"" + today + "\") THEN cost ELSE 0 END) AS curDay_cost, " +
-			"SUM(CASE WHEN TIMESTAMP_TRUNC(_PARTITIONTIME, DAY) = TIMESTAMP(\"" + today + "\") THEN cost ELSE 0 END) - " +
-			"SUM(CASE WHEN TIMESTAMP_TRUNC(_PARTITIONTIME, DAY) = TIMESTAMP(\"" + yesterday + "\") THEN cost ELSE 0 END) AS cost_difference " +
-			"FROM `billing-ftl-cloud.Daily_billing_gcp.gcp_billing_export_v1_017DBD_1FB85B_839E84` " +
-			"GROUP BY project.id " +
-			"HAVING lastDay_cost >= 15 OR curDay_cost >= 15 " +
-			"ORDER BY cost_difference DESC ")
 
-	rows, err := u.getValues(ctx, q)
+func (u *BigQueryUserCase) queryBuilder() (*QueryBuilder, error) {
+	if u.Config == nil {
+		return nil, fmt.Errorf("missing BigQuery configuration")
+	}
+	builder, err := NewQueryBuilder(u.Config.BigQuery.TableID)
 	if err != nil {
 		return nil, err
 	}
-	return rows, nil
-}
-func (u *BigQueryUserCase) DailyCheck(ctx context.Context) ([][]bigquery.Value, error) {
-	rows, err := u.DailyUsage(ctx)
+	dimension, err := ParseDimension(u.Config.Report.GroupBy)
 	if err != nil {
 		return nil, err
 	}
+	costMode, err := ParseCostMode(u.Config.Report.CostMode)
+	if err != nil {
+		return nil, err
+	}
+	return builder.GroupBy(dimension).WithCostMode(costMode), nil
+}
 
-	var res [][]bigquery.Value
-	for idx, row := range rows {
-		rowLen := len(rows[idx])
-		if usageChange, ok := row[rowLen-1].(float64); ok {
-			if lastUsage, ok2 := row[1].(float64); ok2 {
-				if math.Abs(usageChange) > lastUsage*0.3 {
-					res = append(res, row)
-				}
-			}
-		}
-
+// calendar 按配置的报表时区和每周第一天计算统计周期
+func (u *BigQueryUserCase) calendar() (*Calendar, error) {
+	if u.Config == nil {
+		return nil, fmt.Errorf("missing BigQuery configuration")
 	}
-	return res, nil
+	calendar, err := NewCalendar(u.Config.Report.Timezone, u.Config.Report.WeekStart)
+	if err != nil || u.Clock == nil {
+		return calendar, err
+	}
+	return calendar.WithClock(u.Clock), nil
 }
 
-func (u *BigQueryUserCase) getValues(ctx context.Context, q *bigquery.Query) ([][]bigquery.Value, error) {
+// query 将参数化查询转换为 bigquery.Query
+func (u *BigQueryUserCase) query(uq UsageQuery) *bigquery.Query {
+	q := u.Client.Query(uq.SQL)
+	q.Parameters = uq.Params
+	return q
+}
+
+// read 执行查询并返回结果迭代器
+func (u *BigQueryUserCase) read(ctx context.Context, q *bigquery.Query) (*bigquery.RowIterator, error) {
 	// Location must match that of the dataset(s) referenced in the query.
 	q.Location = "asia-southeast1"
 	// Run the query and print results when the query job is completed.
@@ -178,10 +204,17 @@ func (u *BigQueryUserCase) getValues(ctx context.Context, q *bigquery.Query) ([]
 	if err := status.Err(); err != nil {
 		return nil, err
 	}
-	it, err := job.Read(ctx)
-	var rows [][]bigquery.Value
+	return job.Read(ctx)
+}
+
+func (u *BigQueryUserCase) getComparisons(ctx context.Context, q *bigquery.Query, net bool) ([]ProjectCostComparison, error) {
+	it, err := u.read(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	var rows []ProjectCostComparison
 	for {
-		var row []bigquery.Value
+		var row comparisonRow
 		err := it.Next(&row)
 		if err == iterator.Done {
 			break
@@ -189,35 +222,87 @@ func (u *BigQueryUserCase) getValues(ctx context.Context, q *bigquery.Query) ([]
 		if err != nil {
 			return nil, err
 		}
-		//fmt.Println(row)
-		rows = append(rows, row)
-
+		c, err := row.toComparison(net)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, c)
 	}
 	return rows, nil
 }
 
-func getFirstWeekDay() (last, cur, next string) {
-	date := time.Now()
-	weekday := int(date.Weekday())
-	return date.AddDate(0, 0, -weekday-14).Format("2006-01-02"), date.AddDate(0, 0, -weekday-7).Format("2006-01-02"), date.AddDate(0, 0, -weekday).Format("2006-01-02")
+// comparisonRow 用量对比查询结果的一行，列名与 QueryBuilder 生成的 SQL 对应
+type comparisonRow struct {
+	GroupKey      string  `bigquery:"group_key"`
+	ProjectID     string  `bigquery:"project_id"`
+	ProjectName   string  `bigquery:"project_name"`
+	ProjectLabels string  `bigquery:"project_labels"`
+	PreviousCost  float64 `bigquery:"previous_cost"`
+	CurrentCost   float64 `bigquery:"current_cost"`
+	Currency      string  `bigquery:"currency"`
+	// PreviousCredits/CurrentCredits 两期抵扣合计，Credits 按抵扣类型拆分
+	PreviousCredits float64     `bigquery:"previous_credits"`
+	CurrentCredits  float64     `bigquery:"current_credits"`
+	Credits         []creditRow `bigquery:"credits"`
+	// PreviousAdjustments/CurrentAdjustments 只在按发票月统计时返回
+	PreviousAdjustments       float64 `bigquery:"previous_adjustments"`
+	CurrentAdjustments        float64 `bigquery:"current_adjustments"`
+	PreviousAdjustmentCredits float64 `bigquery:"previous_adjustment_credits"`
+	CurrentAdjustmentCredits  float64 `bigquery:"current_adjustment_credits"`
 }
 
-func getFirstMonthDay() (last, cur string) {
-	date := time.Now()
-
-	// 获取当前月的第一天
-	curFirstDay := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, date.Location())
+// creditRow 用量对比查询中某类抵扣的两期合计
+type creditRow struct {
+	Type           string  `bigquery:"credit_type"`
+	PreviousAmount float64 `bigquery:"previous_amount"`
+	CurrentAmount  float64 `bigquery:"current_amount"`
+}
 
-	// 获取上个月的第一天
-	lastFirstDay := curFirstDay.AddDate(0, -1, 0)
+// breakdownRow 服务/SKU 明细查询结果的一行
+type breakdownRow struct {
+	ProjectID    string  `bigquery:"project_id"`
+	Service      string  `bigquery:"service"`
+	SKU          string  `bigquery:"sku"`
+	PreviousCost float64 `bigquery:"previous_cost"`
+	CurrentCost  float64 `bigquery:"current_cost"`
+}
 
-	// 返回格式化的日期
-	return lastFirstDay.Format("2006-01-02"), curFirstDay.Format("2006-01-02")
+// dailyCostRow 每日费用查询结果的一行
+type dailyCostRow struct {
+	ProjectID     string    `bigquery:"project_id"`
+	ProjectName   string    `bigquery:"project_name"`
+	ProjectLabels string    `bigquery:"project_labels"`
+	Day           time.Time `bigquery:"day"`
+	Cost          float64   `bigquery:"cost"`
+	Currency      string    `bigquery:"currency"`
 }
 
-func getTodayAndYesterday() (yes, today string) {
-	date := time.Now()
-	date = date.AddDate(0, 0, -1)
-	yesDate := date.AddDate(0, 0, -1)
-	return yesDate.Format("2006-01-02"), date.Format("2006-01-02")
+// toComparison net 表示查询按 net 口径计算了 previous_cost/current_cost
+func (r comparisonRow) toComparison(net bool) (ProjectCostComparison, error) {
+	c := ProjectCostComparison{
+		Group:           r.GroupKey,
+		ProjectID:       r.ProjectID,
+		ProjectName:     r.ProjectName,
+		PreviousCost:    r.PreviousCost,
+		CurrentCost:     r.CurrentCost,
+		Currency:        r.Currency,
+		PreviousCredits: r.PreviousCredits,
+		CurrentCredits:  r.CurrentCredits,
+		Net:             net,
+
+		PreviousAdjustments:       r.PreviousAdjustments,
+		CurrentAdjustments:        r.CurrentAdjustments,
+		PreviousAdjustmentCredits: r.PreviousAdjustmentCredits,
+		CurrentAdjustmentCredits:  r.CurrentAdjustmentCredits,
+	}
+	for _, credit := range r.Credits {
+		c.Credits = append(c.Credits, CreditTotal{Type: credit.Type, Previous: credit.PreviousAmount, Current: credit.CurrentAmount})
+	}
+	labels, err := decodeLabels(r.ProjectLabels)
+	if err != nil {
+		return c, fmt.Errorf("error decoding labels of project %s: %v", r.ProjectID, err)
+	}
+	c.ProjectLabels = labels
+	c.computeDelta()
+	return c, nil
 }
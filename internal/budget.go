@@ -0,0 +1,301 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var defaultBudgetThresholds = []float64{50, 80, 100, 120}
+
+// BudgetState 某个月已经发送过的预算报警，key 为预算名
+type BudgetState map[string]*BudgetAlertState
+
+type BudgetAlertState struct {
+	Thresholds []float64 `json:"thresholds"`
+	BurnRate   bool      `json:"burnRate"`
+}
+
+// BudgetStateStore 保存每月已报警的预算百分比，保证每个百分比只报警一次
+type BudgetStateStore interface {
+	LoadBudgetState(ctx context.Context, month string) (BudgetState, error)
+	SaveBudgetState(ctx context.Context, month string, state BudgetState) error
+}
+
+var (
+	_ BudgetStateStore = (*StorageCase)(nil)
+	_ BudgetStateStore = (*MemoryBudgetStateStore)(nil)
+)
+
+// MemoryBudgetStateStore 内存中的报警状态，用于离线运行和测试
+type MemoryBudgetStateStore struct {
+	states map[string]BudgetState
+}
+
+func NewMemoryBudgetStateStore() *MemoryBudgetStateStore {
+	return &MemoryBudgetStateStore{states: make(map[string]BudgetState)}
+}
+
+func (m *MemoryBudgetStateStore) LoadBudgetState(ctx context.Context, month string) (BudgetState, error) {
+	state := make(BudgetState)
+	for name, s := range m.states[month] {
+		copied := *s
+		copied.Thresholds = append([]float64(nil), s.Thresholds...)
+		state[name] = &copied
+	}
+	return state, nil
+}
+
+func (m *MemoryBudgetStateStore) SaveBudgetState(ctx context.Context, month string, state BudgetState) error {
+	m.states[month] = state
+	return nil
+}
+
+// readOnlyBudgetStateStore 读取已保存的报警状态，但不保存本次报警
+type readOnlyBudgetStateStore struct {
+	BudgetStateStore
+}
+
+// ReadOnlyBudgetStateStore 用于 dry run，重复运行时报警结果不变
+func ReadOnlyBudgetStateStore(store BudgetStateStore) BudgetStateStore {
+	return readOnlyBudgetStateStore{store}
+}
+
+func (readOnlyBudgetStateStore) SaveBudgetState(ctx context.Context, month string, state BudgetState) error {
+	return nil
+}
+
+// BudgetAlert 预算报警
+type BudgetAlert struct {
+	Budget   string
+	Amount   float64
+	Spent    float64
+	Percent  float64
+	Currency string
+	// Thresholds 本次新越过的报警百分比
+	Thresholds []float64
+	// BurnRate 最近几天的日均费用，ProjectedSpend 按该速度到月末的费用
+	BurnRate       float64
+	ProjectedSpend float64
+	// ExhaustDate 按当前速度预算耗尽的日期，月底前不会耗尽时为零值
+	ExhaustDate time.Time
+}
+
+type budget struct {
+	name       string
+	amount     float64
+	thresholds []float64
+	match      func(c ProjectDailyCost) bool
+}
+
+// BudgetCheckCase 计算本月已用费用，在首次越过报警百分比或消耗速度将在月底前耗尽预算时报警
+type BudgetCheckCase struct {
+	source       BillingSource
+	store        BudgetStateStore
+	budgets      []budget
+	burnRateDays int
+	calendar     *Calendar
+}
+
+// NewBudgetCheckCase calendar 为 nil 时按配置的报表时区创建
+func NewBudgetCheckCase(source BillingSource, store BudgetStateStore, cfg *config.Config, calendar *Calendar) (*BudgetCheckCase, error) {
+	burnRateDays := cfg.BudgetCheck.BurnRateDays
+	if burnRateDays <= 0 {
+		burnRateDays = 3
+	}
+	if calendar == nil {
+		var err error
+		if calendar, err = NewCalendar(cfg.Report.Timezone, cfg.Report.WeekStart); err != nil {
+			return nil, err
+		}
+	}
+	names := make(map[string]bool)
+	var budgets []budget
+	for i, b := range cfg.Budgets {
+		parsed, err := newBudget(b)
+		if err != nil {
+			return nil, fmt.Errorf("budget %d: %v", i, err)
+		}
+		if names[parsed.name] {
+			return nil, fmt.Errorf("budget %d: duplicate name %q", i, parsed.name)
+		}
+		names[parsed.name] = true
+		budgets = append(budgets, parsed)
+	}
+	return &BudgetCheckCase{source: source, store: store, budgets: budgets, burnRateDays: burnRateDays, calendar: calendar}, nil
+}
+
+func newBudget(b config.Budget) (budget, error) {
+	scopes := 0
+	for _, set := range []bool{b.Project != "", b.Label != "", b.BillingAccount} {
+		if set {
+			scopes++
+		}
+	}
+	if scopes != 1 {
+		return budget{}, fmt.Errorf("exactly one of project, label, billingAccount is required")
+	}
+	if b.Amount <= 0 {
+		return budget{}, fmt.Errorf("amount must be positive")
+	}
+
+	res := budget{name: b.Name, amount: b.Amount, thresholds: b.Thresholds}
+	if len(res.thresholds) == 0 {
+		res.thresholds = defaultBudgetThresholds
+	}
+	res.thresholds = append([]float64(nil), res.thresholds...)
+	sort.Float64s(res.thresholds)
+
+	switch {
+	case b.Project != "":
+		res.match = func(c ProjectDailyCost) bool { return c.ProjectID == b.Project }
+		if res.name == "" {
+			res.name = "project:" + b.Project
+		}
+	case b.Label != "":
+		key, value, hasValue := strings.Cut(b.Label, "=")
+		res.match = func(c ProjectDailyCost) bool {
+			v, ok := c.ProjectLabels[key]
+			return ok && (!hasValue || v == value)
+		}
+		if res.name == "" {
+			res.name = "label:" + b.Label
+		}
+	default:
+		res.match = func(c ProjectDailyCost) bool { return true }
+		if res.name == "" {
+			res.name = "billing-account"
+		}
+	}
+	return res, nil
+}
+
+// BudgetCheckResult 预算检查的报警和报警后的状态，报警发送成功后再用 Save 保存状态
+type BudgetCheckResult struct {
+	Alerts []BudgetAlert
+	// Month 状态对应的月份 YYYY-MM
+	Month string
+	// State 记入本次报警后的状态
+	State BudgetState
+}
+
+// Check 检查本月预算，不保存报警状态，避免报警发送失败后不再报警
+func (b *BudgetCheckCase) Check(ctx context.Context) (BudgetCheckResult, error) {
+	if len(b.budgets) == 0 {
+		return BudgetCheckResult{}, nil
+	}
+	now := b.calendar.Now()
+	month := b.calendar.MonthWindow(now)
+	today := b.calendar.Today(now)
+	costs, err := b.source.DailyCosts(ctx, month.CurStart, today)
+	if err != nil {
+		return BudgetCheckResult{}, err
+	}
+
+	monthKey := month.CurStart.Format("2006-01")
+	state, err := b.store.LoadBudgetState(ctx, monthKey)
+	if err != nil {
+		return BudgetCheckResult{}, fmt.Errorf("error loading budget state: %v", err)
+	}
+	if state == nil {
+		state = make(BudgetState)
+	}
+
+	alerts := b.evaluate(costs, month, today, state)
+	return BudgetCheckResult{Alerts: alerts, Month: monthKey, State: state}, nil
+}
+
+// Save 保存检查结果中的报警状态，没有新报警时不保存
+func (b *BudgetCheckCase) Save(ctx context.Context, result BudgetCheckResult) error {
+	if len(result.Alerts) == 0 {
+		return nil
+	}
+	if err := b.store.SaveBudgetState(ctx, result.Month, result.State); err != nil {
+		return fmt.Errorf("error saving budget state: %v", err)
+	}
+	return nil
+}
+
+// evaluate 计算每个预算的报警，并把新报警记入 state
+func (b *BudgetCheckCase) evaluate(costs []ProjectDailyCost, month Window, today time.Time, state BudgetState) []BudgetAlert {
+	burnStart := today.AddDate(0, 0, -b.burnRateDays)
+	if burnStart.Before(month.CurStart) {
+		burnStart = month.CurStart
+	}
+	burnDays := today.Sub(burnStart).Hours() / 24
+	remainingDays := month.CurEnd.Sub(today).Hours() / 24
+
+	var alerts []BudgetAlert
+	for _, bg := range b.budgets {
+		alert := BudgetAlert{Budget: bg.name, Amount: bg.amount}
+		var recent float64
+		for _, c := range costs {
+			if !bg.match(c) || c.Day.Before(month.CurStart) || !c.Day.Before(today) {
+				continue
+			}
+			alert.Spent += c.Cost
+			if !c.Day.Before(burnStart) {
+				recent += c.Cost
+			}
+			if alert.Currency == "" {
+				alert.Currency = c.Currency
+			}
+		}
+		alert.Percent = alert.Spent / bg.amount * 100
+		if burnDays > 0 {
+			alert.BurnRate = recent / burnDays
+		}
+		alert.ProjectedSpend = alert.Spent + alert.BurnRate*remainingDays
+
+		s, ok := state[bg.name]
+		if !ok {
+			s = &BudgetAlertState{}
+			state[bg.name] = s
+		}
+		for _, threshold := range bg.thresholds {
+			if alert.Percent >= threshold && !containsThreshold(s.Thresholds, threshold) {
+				alert.Thresholds = append(alert.Thresholds, threshold)
+				s.Thresholds = append(s.Thresholds, threshold)
+			}
+		}
+
+		// 尚未用完预算，但按当前速度会在月底前耗尽
+		burnAlert := false
+		if alert.Spent < bg.amount && alert.BurnRate > 0 && alert.ProjectedSpend > bg.amount {
+			days := math.Ceil((bg.amount - alert.Spent) / alert.BurnRate)
+			alert.ExhaustDate = today.AddDate(0, 0, int(days)-1)
+			if !s.BurnRate {
+				s.BurnRate = true
+				burnAlert = true
+			}
+		}
+
+		if len(alert.Thresholds) > 0 || burnAlert {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+func containsThreshold(thresholds []float64, threshold float64) bool {
+	for _, t := range thresholds {
+		if t == threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// formatThresholds 如 "50%, 80%"
+func formatThresholds(thresholds []float64) string {
+	parts := make([]string, 0, len(thresholds))
+	for _, t := range thresholds {
+		parts = append(parts, strconv.FormatFloat(t, 'f', -1, 64)+"%")
+	}
+	return strings.Join(parts, ", ")
+}
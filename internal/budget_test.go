@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBudgetCheckEvaluate(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 4, d, 0, 0, 0, 0, time.UTC) }
+	month := Window{PrevStart: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), PrevEnd: day(1), CurStart: day(1), CurEnd: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)}
+	prod := map[string]string{"env": "prod"}
+
+	cfg := &config.Config{Budgets: []config.Budget{
+		{Project: "web", Amount: 1000},
+		{Name: "prod", Label: "env=prod", Amount: 3000, Thresholds: []float64{100, 50}},
+		{BillingAccount: true, Amount: 100000},
+	}}
+	checkCase, err := NewBudgetCheckCase(&MemorySource{}, NewMemoryBudgetStateStore(), cfg, nil)
+	require.NoError(t, err)
+
+	// web 每天 60，api 每天 40，都带 env=prod 标签
+	costsUntil := func(today time.Time) []ProjectDailyCost {
+		var costs []ProjectDailyCost
+		for d := day(1); d.Before(today); d = d.AddDate(0, 0, 1) {
+			costs = append(costs,
+				ProjectDailyCost{ProjectID: "web", Day: d, Cost: 60, Currency: "USD", ProjectLabels: prod},
+				ProjectDailyCost{ProjectID: "api", Day: d, Cost: 40, Currency: "USD", ProjectLabels: prod},
+			)
+		}
+		return costs
+	}
+
+	state := make(BudgetState)
+	// 4/10: web 已用 540 (54%)，按每天 60 将在 4/17 用完；prod 已用 900 (30%)，月底预计 3000，不会提前耗尽
+	alerts := checkCase.evaluate(costsUntil(day(10)), month, day(10), state)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "project:web", alerts[0].Budget)
+	assert.Equal(t, 540.0, alerts[0].Spent)
+	assert.Equal(t, []float64{50}, alerts[0].Thresholds)
+	assert.Equal(t, 60.0, alerts[0].BurnRate)
+	assert.Equal(t, day(17), alerts[0].ExhaustDate)
+	assert.Equal(t, "USD", alerts[0].Currency)
+
+	// 同一天再次检查不会重复报警
+	assert.Empty(t, checkCase.evaluate(costsUntil(day(10)), month, day(10), state))
+
+	// 4/16: web 900 (90%) 越过 80%；prod 1500 越过 50%
+	alerts = checkCase.evaluate(costsUntil(day(16)), month, day(16), state)
+	require.Len(t, alerts, 2)
+	assert.Equal(t, []float64{80}, alerts[0].Thresholds)
+	assert.Equal(t, "prod", alerts[1].Budget)
+	assert.Equal(t, 1500.0, alerts[1].Spent)
+	assert.Equal(t, []float64{50}, alerts[1].Thresholds)
+	assert.True(t, alerts[1].ExhaustDate.IsZero())
+
+	// 月末: 跳过的百分比一次性报出
+	alerts = checkCase.evaluate(costsUntil(month.CurEnd), month, month.CurEnd, state)
+	require.Len(t, alerts, 2)
+	assert.Equal(t, []float64{100, 120}, alerts[0].Thresholds)
+	assert.Equal(t, []float64{100}, alerts[1].Thresholds)
+}
+
+func TestNewBudgetCheckCaseValidation(t *testing.T) {
+	invalid := [][]config.Budget{
+		{{Amount: 100}},
+		{{Project: "a", Label: "env=prod", Amount: 100}},
+		{{Project: "a"}},
+		{{Project: "a", Amount: 100}, {Name: "project:a", BillingAccount: true, Amount: 100}},
+	}
+	for _, budgets := range invalid {
+		_, err := NewBudgetCheckCase(&MemorySource{}, NewMemoryBudgetStateStore(), &config.Config{Budgets: budgets}, nil)
+		assert.Error(t, err)
+	}
+}
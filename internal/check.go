@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"context"
+	"fmt"
+)
+
+// UsageCheckCase 基于任意 BillingSource 按配置的规则检查用量异常
+// 按项目以外的维度分组时，检查的是每个分组的合计费用
+// 各周期的规则可以单独选择按 gross 或 net 费用检查，为空时使用数据源的费用口径
+type UsageCheckCase struct {
+	source     BillingSource
+	dailyRules *RulePolicy
+	weekRules  *RulePolicy
+	monthRules *RulePolicy
+	dailyMode  string
+	weekMode   string
+	monthMode  string
+	// monthBasis 为 invoice 时月用量按发票月检查
+	monthBasis string
+	calendar   *Calendar
+}
+
+// NewUsageCheckCase calendar 为 nil 时按配置的报表时区创建
+func NewUsageCheckCase(source BillingSource, cfg *config.Config, calendar *Calendar) (*UsageCheckCase, error) {
+	overrides := cfg.Rules.Overrides
+	dailyRules, err := NewRulePolicy(cfg.Rules.Daily, defaultDailyRules, overrides, func(o config.RuleOverride) config.RuleSet { return o.Daily })
+	if err != nil {
+		return nil, fmt.Errorf("invalid daily rules: %v", err)
+	}
+	weekRules, err := NewRulePolicy(cfg.Rules.Week, defaultWeekRules, overrides, func(o config.RuleOverride) config.RuleSet { return o.Week })
+	if err != nil {
+		return nil, fmt.Errorf("invalid week rules: %v", err)
+	}
+	monthRules, err := NewRulePolicy(cfg.Rules.Month, defaultMonthRules, overrides, func(o config.RuleOverride) config.RuleSet { return o.Month })
+	if err != nil {
+		return nil, fmt.Errorf("invalid month rules: %v", err)
+	}
+	monthBasis, err := ParseMonthBasis(cfg.Report.MonthBasis)
+	if err != nil {
+		return nil, err
+	}
+	if calendar == nil {
+		if calendar, err = NewCalendar(cfg.Report.Timezone, cfg.Report.WeekStart); err != nil {
+			return nil, err
+		}
+	}
+	check := &UsageCheckCase{
+		source:     source,
+		dailyRules: dailyRules,
+		weekRules:  weekRules,
+		monthRules: monthRules,
+		monthBasis: monthBasis,
+		calendar:   calendar,
+	}
+	for _, m := range []struct {
+		name  string
+		value string
+		mode  *string
+	}{
+		{"daily", cfg.Rules.Daily.CostMode, &check.dailyMode},
+		{"week", cfg.Rules.Week.CostMode, &check.weekMode},
+		{"month", cfg.Rules.Month.CostMode, &check.monthMode},
+	} {
+		if m.value == "" {
+			continue
+		}
+		if *m.mode, err = ParseCostMode(m.value); err != nil {
+			return nil, fmt.Errorf("invalid %s rules: %v", m.name, err)
+		}
+	}
+	return check, nil
+}
+
+// CheckResult 一个周期的检查结果
+type CheckResult struct {
+	// Checked 参与检查的项目或分组数
+	Checked   int
+	Anomalies []Anomaly
+	// Rows 检查的各项目费用，用于保存报表
+	Rows []ProjectCostComparison
+}
+
+func check(rows []ProjectCostComparison, rules *RulePolicy, mode string) CheckResult {
+	totals := GroupTotals(WithCostMode(rows, mode))
+	return CheckResult{Checked: len(totals), Anomalies: rules.Check(totals), Rows: rows}
+}
+
+func (u *UsageCheckCase) DailyCheck(ctx context.Context) (CheckResult, error) {
+	rows, err := u.source.DailyUsage(ctx)
+	if err != nil {
+		return CheckResult{}, err
+	}
+	return check(rows, u.dailyRules, u.dailyMode), nil
+}
+
+func (u *UsageCheckCase) WeekCheck(ctx context.Context) (CheckResult, error) {
+	rows, err := u.source.WeekUsage(ctx)
+	if err != nil {
+		return CheckResult{}, err
+	}
+	return check(rows, u.weekRules, u.weekMode), nil
+}
+
+func (u *UsageCheckCase) MonthCheck(ctx context.Context) (CheckResult, error) {
+	rows, err := u.MonthUsage(ctx)
+	if err != nil {
+		return CheckResult{}, err
+	}
+	return check(rows, u.monthRules, u.monthMode), nil
+}
+
+// MonthUsage 按配置的统计方式返回月用量
+func (u *UsageCheckCase) MonthUsage(ctx context.Context) ([]ProjectCostComparison, error) {
+	if u.monthBasis == monthBasisInvoice {
+		return u.source.InvoiceMonthUsage(ctx)
+	}
+	return u.source.MonthUsage(ctx)
+}
+
+// MonthReconciliation 按发票月统计时，对比本月按分区与按发票月统计的费用，否则返回 nil
+func (u *UsageCheckCase) MonthReconciliation(ctx context.Context, invoice []ProjectCostComparison) ([]MonthReconciliation, error) {
+	if u.monthBasis != monthBasisInvoice {
+		return nil, nil
+	}
+	usage, err := u.source.MonthUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return Reconcile(usage, invoice), nil
+}
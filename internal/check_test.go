@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageCheckCaseWithMemorySource(t *testing.T) {
+	ctx := context.Background()
+	rows := []ProjectCostComparison{
+		comparison("steady", 100, 110),
+		comparison("spike", 100, 200),
+		comparison("drop", 100, 20),
+	}
+	weekRows := []ProjectCostComparison{
+		comparison("big-but-steady", 10000, 10600),
+		comparison("steady", 100, 110),
+	}
+	checkCase, err := NewUsageCheckCase(NewMemorySource(rows, weekRows, rows), &config.Config{}, nil)
+	assert.NoError(t, err)
+
+	daily, err := checkCase.DailyCheck(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, daily.Checked)
+	assert.Equal(t, []Anomaly{
+		{ProjectCostComparison: rows[1], Rules: []string{"daily-relative-30%"}},
+		{ProjectCostComparison: rows[2], Rules: []string{"daily-relative-30%"}},
+	}, daily.Anomalies)
+
+	week, err := checkCase.WeekCheck(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, week.Checked)
+	assert.Equal(t, []Anomaly{{ProjectCostComparison: weekRows[0], Rules: []string{"week-increase-500"}}}, week.Anomalies)
+
+	month, err := checkCase.MonthCheck(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, month.Anomalies, 2)
+}
+
+func comparison(projectID string, prev, cur float64) ProjectCostComparison {
+	c := ProjectCostComparison{ProjectID: projectID, PreviousCost: prev, CurrentCost: cur, Currency: "USD"}
+	c.computeDelta()
+	return c
+}
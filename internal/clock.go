@@ -0,0 +1,33 @@
+package internal
+
+import "time"
+
+// Clock 当前时间的来源，测试中替换为固定时间即可复现任意一天的行为
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc 将函数适配为 Clock
+type ClockFunc func() time.Time
+
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// SystemClock 系统时间
+var SystemClock Clock = ClockFunc(time.Now)
+
+// FixedClock 始终返回 t
+func FixedClock(t time.Time) Clock {
+	return ClockFunc(func() time.Time { return t })
+}
+
+// reportDate 报表日期，即 clock 当前的日期
+func reportDate(clock Clock) string {
+	return clock.Now().Format("2006-01-02")
+}
+
+// reportFileName 报表文件名，如 week_usage_2024-03-11.xlsx
+func reportFileName(kind string, clock Clock) string {
+	return kind + "_usage_" + reportDate(clock) + ".xlsx"
+}
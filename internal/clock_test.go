@@ -0,0 +1,23 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalendarClock(t *testing.T) {
+	calendar, err := NewCalendar("Asia/Shanghai", "")
+	require.NoError(t, err)
+
+	// UTC 12/31 17:00 在上海已是 1/1
+	clock := calendar.WithClock(FixedClock(time.Date(2023, 12, 31, 17, 0, 0, 0, time.UTC)))
+	assert.Equal(t, time.Date(2024, 1, 1, 1, 0, 0, 0, calendar.location), clock.Now())
+	assert.Equal(t, "month_usage_2024-01-01.xlsx", reportFileName("month", clock))
+	assert.Equal(t, "2023-12-01 ~ 2023-12-31 / 2024-01-01 ~ 2024-01-31", clock.MonthWindow(clock.Now()).String())
+
+	// WithClock 不修改原 calendar
+	assert.WithinDuration(t, time.Now(), calendar.Now(), time.Minute)
+}
@@ -12,11 +12,22 @@ type Config struct {
 		TableID   string `yaml:"tableID"`
 	} `yaml:"bigQuery"`
 
+	// 账单数据来源，type 为 bigquery(默认) 或 file
+	Source struct {
+		Type  string   `yaml:"type"`
+		Files []string `yaml:"files"`
+	} `yaml:"source"`
+
+	// Webhook 钉钉机器人，设置 url 时与 notifiers 中的渠道一起接收通知
 	Webhook struct {
-		URL     string `yaml:"url"`
+		URL string `yaml:"url"`
+		// KeyWord 机器人安全设置中的自定义关键词，使用加签或 IP 白名单时可以为空
 		KeyWord string `yaml:"keyWord"`
 	} `yaml:"webhook"`
 
+	// Notifiers 通知渠道，每条通知发送到所有渠道
+	Notifiers []Notifier `yaml:"notifiers"`
+
 	Storage struct {
 		Bucket    string `yaml:"bucket"`
 		ProjectID string `yaml:"projectID"`
@@ -30,6 +41,172 @@ type Config struct {
 	} `yaml:"email"`
 
 	Recipients []string `yaml:"recipients"`
+
+	Report struct {
+		// GroupBy 日/周/月用量的分组维度: project(默认)、label:<key>、service、region、billing_account
+		GroupBy string `yaml:"groupBy"`
+		// CostMode gross(只统计 cost 列，默认) 或 net(扣除 credits 后的金额，与发票一致)
+		// 报表、基线、预测和预算都使用该口径
+		CostMode string `yaml:"costMode"`
+		// MonthBasis 月用量的统计方式: usage(按 _PARTITIONTIME 所在月，默认) 或 invoice(按 invoice.month)
+		// invoice 方式下月用量检查和月报表按发票月统计，月报表额外包含延迟调整和对账工作表
+		MonthBasis string `yaml:"monthBasis"`
+		// Timezone 计算日/周/月边界使用的 IANA 时区，如 Asia/Shanghai，为空时使用运行环境的本地时区
+		Timezone string `yaml:"timezone"`
+		// WeekStart 每周第一天，如 monday(默认)、sunday
+		WeekStart string `yaml:"weekStart"`
+	} `yaml:"report"`
+
+	// 日/周/月异常规则，未配置的周期使用内置默认规则
+	Rules struct {
+		Daily RuleSet `yaml:"daily"`
+		Week  RuleSet `yaml:"week"`
+		Month RuleSet `yaml:"month"`
+		// Overrides 按项目覆盖默认规则
+		// 优先级: project > projectRegex > projectGlob > label，同一类型按配置顺序取第一个
+		Overrides []RuleOverride `yaml:"overrides"`
+	} `yaml:"rules"`
+
+	Baseline Baseline `yaml:"baseline"`
+
+	// 日用量异常项目的服务/SKU 明细
+	DrillDown struct {
+		Enabled bool `yaml:"enabled"`
+		// TopN 每个项目展示的服务和 SKU 数量，默认 5
+		TopN int `yaml:"topN"`
+	} `yaml:"drillDown"`
+
+	// 月末费用预测
+	Forecast struct {
+		Enabled bool `yaml:"enabled"`
+		// Method linear(按日均线性外推，默认) 或 weekday(按星期几加权)
+		Method string `yaml:"method"`
+		// HistoryDays weekday 方式计算星期权重使用的历史天数，默认 28
+		HistoryDays int `yaml:"historyDays"`
+		// TopN 消息中展示预测增长最多的项目数，超出预算的项目总会展示，默认 10
+		TopN int `yaml:"topN"`
+	} `yaml:"forecast"`
+
+	// 月度预算
+	Budgets []Budget `yaml:"budgets"`
+
+	BudgetCheck struct {
+		// BurnRateDays 计算当前日消耗速度使用的最近完整天数，默认 3
+		BurnRateDays int `yaml:"burnRateDays"`
+	} `yaml:"budgetCheck"`
+
+	Schedule Schedule `yaml:"schedule"`
+}
+
+// Schedule 各任务的 cron 表达式(分 时 日 月 周)，按报表时区计算，为空时使用默认时间，off 表示不执行
+type Schedule struct {
+	// Interval 定时器的触发间隔，自上次触发以来表达式到达过的任务都会执行，默认 24h
+	Interval string `yaml:"interval"`
+	// DailyCheck 日用量检查(含服务明细和基线检查)，默认每天
+	DailyCheck string `yaml:"dailyCheck"`
+	// WeekCheck 周用量检查，默认周二
+	WeekCheck string `yaml:"weekCheck"`
+	// MonthCheck 月用量检查，默认每月 2 号
+	MonthCheck string `yaml:"monthCheck"`
+	// WeekReport 周报表和邮件，默认周一
+	WeekReport string `yaml:"weekReport"`
+	// MonthReport 月报表、月末预测和邮件，默认周一
+	MonthReport string `yaml:"monthReport"`
+	// BudgetCheck 预算检查，默认每天
+	BudgetCheck string `yaml:"budgetCheck"`
+}
+
+// Notifier 一个通知渠道
+type Notifier struct {
+	// Type 渠道类型，如 dingtalk
+	Type string `yaml:"type"`
+	// Name 渠道名称，用于日志和错误信息，默认为 type
+	Name string `yaml:"name"`
+	// URL 机器人 webhook 地址
+	URL string `yaml:"url"`
+	// KeyWord 机器人安全设置中的自定义关键词，只用于 dingtalk，为空时消息不加关键词
+	KeyWord string `yaml:"keyWord"`
+	// Secret 机器人安全设置中的签名校验密钥，目前只有 feishu 支持
+	Secret string `yaml:"secret"`
+	// Mentions 通知中出现对应项目时 @ 的成员，目前只有 wecom 支持
+	Mentions []Mention `yaml:"mentions"`
+}
+
+// Mention 通知中出现 project 时 @ 的成员
+type Mention struct {
+	// Project 项目 id，按其他维度分组时为分组键，预算报警为预算名称，* 匹配所有项目
+	Project string `yaml:"project"`
+	// UserIDs 成员的 userid
+	UserIDs []string `yaml:"userIDs"`
+	// Mobiles 成员的手机号
+	Mobiles []string `yaml:"mobiles"`
+}
+
+// Budget 月度预算，project、label、billingAccount 只能设置一个
+type Budget struct {
+	Name    string `yaml:"name"`
+	Project string `yaml:"project"`
+	// Label 项目标签 key=value，汇总带该标签的所有项目
+	Label string `yaml:"label"`
+	// BillingAccount 为 true 时预算覆盖整个账单账户
+	BillingAccount bool    `yaml:"billingAccount"`
+	Amount         float64 `yaml:"amount"`
+	// Thresholds 报警百分比，每个百分比每月只报警一次，默认 50/80/100/120
+	Thresholds []float64 `yaml:"thresholds"`
+}
+
+// RuleOverride 匹配到的项目使用单独的规则，每个覆盖项只能设置一种匹配方式，未设置的周期沿用默认规则
+// 按项目以外的维度分组时，project/projectGlob/projectRegex 匹配分组键
+type RuleOverride struct {
+	Project      string `yaml:"project"`
+	ProjectGlob  string `yaml:"projectGlob"`
+	ProjectRegex string `yaml:"projectRegex"`
+	// Label 项目标签，key=value 或 key(只要求存在)
+	Label string `yaml:"label"`
+
+	Daily RuleSet `yaml:"daily"`
+	Week  RuleSet `yaml:"week"`
+	Month RuleSet `yaml:"month"`
+}
+
+// Baseline 基于滚动基线的日费用异常检测
+type Baseline struct {
+	Enabled bool `yaml:"enabled"`
+	// WindowDays 基线使用的历史天数，默认 28
+	WindowDays int `yaml:"windowDays"`
+	// Method stddev(均值 ± k 倍标准差) 或 mad(中位数 ± k 倍 MAD，默认)
+	Method string  `yaml:"method"`
+	K      float64 `yaml:"k"`
+	// Seasonality 只与历史中同一星期几的费用比较
+	Seasonality bool `yaml:"seasonality"`
+	// MinSamples 历史样本少于该值时不检查，默认 3
+	MinSamples int `yaml:"minSamples"`
+	// MinSpend 实际与预期费用都低于该值时不检查，默认 15
+	MinSpend float64 `yaml:"minSpend"`
+	// MinDeviation 偏离预期的金额低于该值时不报警，避免历史完全平稳时的微小波动，默认 10
+	MinDeviation float64 `yaml:"minDeviation"`
+}
+
+// RuleSet 一个周期的异常规则集合
+type RuleSet struct {
+	// Combine 多条规则的组合方式: or(任一规则触发即异常，默认) 或 and(全部规则触发才异常)
+	Combine string `yaml:"combine"`
+	Rules   []Rule `yaml:"rules"`
+	// CostMode 规则检查使用的费用口径 gross/net，为空时与 report.costMode 一致，覆盖规则中的设置无效
+	CostMode string `yaml:"costMode"`
+}
+
+// Rule 单条异常规则，规则内设置的各项条件需同时满足
+type Rule struct {
+	Name string `yaml:"name"`
+	// RelativeChange 变化金额超过上期费用的比例，如 0.3 表示 30%
+	RelativeChange float64 `yaml:"relativeChange"`
+	// AbsoluteChange 变化金额超过该值
+	AbsoluteChange float64 `yaml:"absoluteChange"`
+	// MinSpend 两期费用都低于该值的项目不检查
+	MinSpend float64 `yaml:"minSpend"`
+	// Direction increase(只检查上涨) 或 both(上涨和下降，默认)
+	Direction string `yaml:"direction"`
 }
 
 // LoadConfig reads the YAML configuration from the given file path
@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// costModeGross 只统计 cost 列，与账单导出表的原始费用一致
+	costModeGross = "gross"
+	// costModeNet cost 加上 credits(持续使用折扣、承诺使用折扣、促销、免费层等)，与发票金额一致
+	costModeNet = "net"
+)
+
+// CreditTotal 某一类抵扣在相邻两个周期的合计，抵扣金额为负数
+type CreditTotal struct {
+	Type     string
+	Previous float64
+	Current  float64
+}
+
+// ParseCostMode 支持 gross(默认) 与 net
+func ParseCostMode(value string) (string, error) {
+	switch mode := strings.ToLower(strings.TrimSpace(value)); mode {
+	case "", costModeGross:
+		return costModeGross, nil
+	case costModeNet:
+		return costModeNet, nil
+	default:
+		return "", fmt.Errorf("invalid cost mode %q, expected gross/net", value)
+	}
+}
+
+// creditType 抵扣类型，导出数据缺少 type 时使用抵扣名称
+func (c Credit) creditType() string {
+	if c.Type != "" {
+		return c.Type
+	}
+	return c.Name
+}
+
+// creditSum 账单行上所有抵扣的合计
+func creditSum(credits []Credit) float64 {
+	var sum float64
+	for _, c := range credits {
+		sum += c.Amount
+	}
+	return sum
+}
+
+// addCredits 将一行账单的抵扣按类型计入 row
+func (c *ProjectCostComparison) addCredits(credits []Credit, prev, cur bool) {
+	for _, credit := range credits {
+		total := CreditTotal{Type: credit.creditType()}
+		if prev {
+			total.Previous = credit.Amount
+		}
+		if cur {
+			total.Current = credit.Amount
+		}
+		c.addCreditTotal(total)
+	}
+}
+
+// addCreditTotal 合并同类型的抵扣，并计入两期抵扣合计
+func (c *ProjectCostComparison) addCreditTotal(total CreditTotal) {
+	c.PreviousCredits += total.Previous
+	c.CurrentCredits += total.Current
+	for i := range c.Credits {
+		if c.Credits[i].Type == total.Type {
+			c.Credits[i].Previous += total.Previous
+			c.Credits[i].Current += total.Current
+			return
+		}
+	}
+	c.Credits = append(c.Credits, total)
+}
+
+// withCostMode 按 mode 换算 PreviousCost/CurrentCost 并重新计算差值
+func (c ProjectCostComparison) withCostMode(mode string) ProjectCostComparison {
+	net := mode == costModeNet
+	if c.Net == net {
+		return c
+	}
+	sign := 1.0
+	if !net {
+		sign = -1
+	}
+	c.PreviousCost += sign * c.PreviousCredits
+	c.CurrentCost += sign * c.CurrentCredits
+	c.PreviousAdjustments += sign * c.PreviousAdjustmentCredits
+	c.CurrentAdjustments += sign * c.CurrentAdjustmentCredits
+	c.Net = net
+	c.computeDelta()
+	return c
+}
+
+// WithCostMode 返回按 gross 或 net 计算费用的 rows，按用量差降序排列，mode 为空时原样返回
+func WithCostMode(rows []ProjectCostComparison, mode string) []ProjectCostComparison {
+	if mode == "" {
+		return rows
+	}
+	res := make([]ProjectCostComparison, len(rows))
+	for i, row := range rows {
+		res[i] = row.withCostMode(mode)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Delta > res[j].Delta
+	})
+	return res
+}
+
+// sortCredits 抵扣类型按名称排序，保证报表列顺序稳定
+func sortCredits(credits []CreditTotal) {
+	sort.Slice(credits, func(i, j int) bool {
+		return credits[i].Type < credits[j].Type
+	})
+}
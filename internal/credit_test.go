@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCostMode(t *testing.T) {
+	for value, want := range map[string]string{"": costModeGross, "gross": costModeGross, "NET": costModeNet} {
+		mode, err := ParseCostMode(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, want, mode, value)
+	}
+	_, err := ParseCostMode("invoice")
+	assert.Error(t, err)
+}
+
+func TestWithCostMode(t *testing.T) {
+	row := comparison("promo", 100, 100)
+	row.addCreditTotal(CreditTotal{Type: "PROMOTION", Current: -60})
+
+	net := WithCostMode([]ProjectCostComparison{row}, costModeNet)
+	assert.Equal(t, 40.0, net[0].CurrentCost)
+	assert.Equal(t, -60.0, net[0].Delta)
+	assert.True(t, net[0].Net)
+
+	// 重复换算不会重复扣除抵扣，换回 gross 与原始数据一致
+	assert.Equal(t, net, WithCostMode(net, costModeNet))
+	assert.Equal(t, []ProjectCostComparison{row}, WithCostMode(net, costModeGross))
+	assert.Equal(t, net, WithCostMode(net, ""))
+}
+
+func TestUsageCheckCaseCostMode(t *testing.T) {
+	// 总费用不变，但促销抵扣结束导致净费用翻倍
+	row := comparison("promo", 100, 100)
+	row.addCreditTotal(CreditTotal{Type: "PROMOTION", Previous: -50})
+	rows := []ProjectCostComparison{row}
+
+	gross, err := NewUsageCheckCase(NewMemorySource(rows, rows, rows), &config.Config{}, nil)
+	require.NoError(t, err)
+	result, err := gross.DailyCheck(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, result.Anomalies)
+
+	cfg := &config.Config{}
+	cfg.Rules.Daily.CostMode = "net"
+	net, err := NewUsageCheckCase(NewMemorySource(rows, rows, rows), cfg, nil)
+	require.NoError(t, err)
+	result, err = net.DailyCheck(context.Background())
+	require.NoError(t, err)
+	anomalies := result.Anomalies
+	require.Len(t, anomalies, 1)
+	assert.Equal(t, 50.0, anomalies[0].PreviousCost)
+	assert.Equal(t, 100.0, anomalies[0].DeltaPercent)
+
+	cfg.Rules.Week.CostMode = "invoice"
+	_, err = NewUsageCheckCase(NewMemorySource(rows, rows, rows), cfg, nil)
+	assert.Error(t, err)
+}
+
+func TestGroupTotalsCredits(t *testing.T) {
+	a := ProjectCostComparison{Group: "team", ProjectID: "a", CurrentCost: 10}
+	a.addCreditTotal(CreditTotal{Type: "SUD", Current: -1})
+	b := ProjectCostComparison{Group: "team", ProjectID: "b", CurrentCost: 20}
+	b.addCreditTotal(CreditTotal{Type: "SUD", Current: -2})
+	b.addCreditTotal(CreditTotal{Type: "CUD", Current: -3})
+
+	totals := GroupTotals([]ProjectCostComparison{a, b})
+	require.Len(t, totals, 1)
+	assert.Equal(t, -6.0, totals[0].CurrentCredits)
+	assert.Equal(t, []CreditTotal{{Type: "CUD", Current: -3}, {Type: "SUD", Current: -3}}, totals[0].Credits)
+
+	sheets := usageSheets([]ProjectCostComparison{a, b}, []string{"项目id"}, nil)
+	credits := sheets[len(sheets)-1]
+	assert.Equal(t, "抵扣明细", credits.name)
+	assert.Len(t, credits.rows, 3)
+}
@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	dimensionProject        = "project"
+	dimensionLabel          = "label"
+	dimensionService        = "service"
+	dimensionRegion         = "region"
+	dimensionBillingAccount = "billing_account"
+
+	// unlabeledGroup 没有分组标签的费用单独归为一组
+	unlabeledGroup = "(unlabeled)"
+	// noneGroup 服务、区域等字段为空的费用
+	noneGroup = "(none)"
+)
+
+// Dimension 用量汇总的分组维度，LabelKey 仅在按标签分组时使用
+type Dimension struct {
+	Kind     string
+	LabelKey string
+}
+
+// ParseDimension 支持 project(默认)、label:<key>、service、region、billing_account
+func ParseDimension(value string) (Dimension, error) {
+	value = strings.TrimSpace(value)
+	if key, ok := strings.CutPrefix(value, dimensionLabel+":"); ok {
+		if key == "" {
+			return Dimension{}, fmt.Errorf("label dimension requires a key, e.g. label:team")
+		}
+		return Dimension{Kind: dimensionLabel, LabelKey: key}, nil
+	}
+	switch value {
+	case "", dimensionProject:
+		return Dimension{Kind: dimensionProject}, nil
+	case dimensionService, dimensionRegion, dimensionBillingAccount:
+		return Dimension{Kind: value}, nil
+	default:
+		return Dimension{}, fmt.Errorf("invalid group dimension %q", value)
+	}
+}
+
+func (d Dimension) String() string {
+	if d.Kind == dimensionLabel {
+		return dimensionLabel + ":" + d.LabelKey
+	}
+	if d.Kind == "" {
+		return dimensionProject
+	}
+	return d.Kind
+}
+
+// IsProject 按项目分组时各行的 Group 为空
+func (d Dimension) IsProject() bool {
+	return d.Kind == "" || d.Kind == dimensionProject
+}
+
+// sqlExpr 分组键的 SQL 表达式，按标签分组时使用 @group_label 参数
+func (d Dimension) sqlExpr() string {
+	switch d.Kind {
+	case dimensionLabel:
+		return "IFNULL((SELECT l.value FROM UNNEST(labels) AS l WHERE l.key = @group_label LIMIT 1), '" + unlabeledGroup + "')"
+	case dimensionService:
+		return "IFNULL(NULLIF(service.description, ''), '" + noneGroup + "')"
+	case dimensionRegion:
+		return "IFNULL(NULLIF(location.region, ''), '" + noneGroup + "')"
+	case dimensionBillingAccount:
+		return "IFNULL(NULLIF(billing_account_id, ''), '" + noneGroup + "')"
+	default:
+		return "''"
+	}
+}
+
+// recordGroup 与 sqlExpr 对应，计算导出文件中一行的分组键
+func (d Dimension) recordGroup(r BillingRecord) string {
+	value := ""
+	switch d.Kind {
+	case dimensionLabel:
+		if v, ok := r.Labels[d.LabelKey]; ok {
+			return v
+		}
+		return unlabeledGroup
+	case dimensionService:
+		value = r.Service
+	case dimensionRegion:
+		value = r.Region
+	case dimensionBillingAccount:
+		value = r.BillingAccountID
+	default:
+		return ""
+	}
+	if value == "" {
+		return noneGroup
+	}
+	return value
+}
+
+// GroupTotals 将同一分组下各项目的费用合并为一行，rows 未分组时原样返回
+func GroupTotals(rows []ProjectCostComparison) []ProjectCostComparison {
+	byGroup := make(map[string]*ProjectCostComparison)
+	var order []string
+	for _, row := range rows {
+		if row.Group == "" {
+			return rows
+		}
+		g, ok := byGroup[row.Group]
+		if !ok {
+			g = &ProjectCostComparison{Group: row.Group, Currency: row.Currency, Net: row.Net}
+			byGroup[row.Group] = g
+			order = append(order, row.Group)
+		}
+		g.PreviousCost += row.PreviousCost
+		g.CurrentCost += row.CurrentCost
+		g.PreviousAdjustments += row.PreviousAdjustments
+		g.CurrentAdjustments += row.CurrentAdjustments
+		g.PreviousAdjustmentCredits += row.PreviousAdjustmentCredits
+		g.CurrentAdjustmentCredits += row.CurrentAdjustmentCredits
+		for _, credit := range row.Credits {
+			g.addCreditTotal(credit)
+		}
+	}
+
+	res := make([]ProjectCostComparison, 0, len(order))
+	for _, group := range order {
+		g := byGroup[group]
+		sortCredits(g.Credits)
+		g.computeDelta()
+		res = append(res, *g)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Delta > res[j].Delta
+	})
+	return res
+}
@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDimension(t *testing.T) {
+	for value, want := range map[string]Dimension{
+		"":                {Kind: dimensionProject},
+		"project":         {Kind: dimensionProject},
+		"label:team":      {Kind: dimensionLabel, LabelKey: "team"},
+		"service":         {Kind: dimensionService},
+		"region":          {Kind: dimensionRegion},
+		"billing_account": {Kind: dimensionBillingAccount},
+	} {
+		d, err := ParseDimension(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, want, d, value)
+	}
+
+	for _, value := range []string{"label:", "sku", "team"} {
+		_, err := ParseDimension(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestCompareRecordsByLabel(t *testing.T) {
+	records, err := LoadBillingExport("testdata/billing_export.csv")
+	require.NoError(t, err)
+
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+	w := Window{PrevStart: day(4), PrevEnd: day(5), CurStart: day(5), CurEnd: day(6)}
+
+	rows := compareRecords(records, Dimension{Kind: dimensionLabel, LabelKey: "env"}, costModeGross, w)
+	totals := GroupTotals(rows)
+	require.Len(t, totals, 2)
+	// 没有 env 标签的费用归入 (unlabeled)
+	assert.Equal(t, unlabeledGroup, totals[0].Group)
+	assert.Equal(t, 35.0, totals[0].CurrentCost)
+	assert.Equal(t, "prod", totals[1].Group)
+	assert.Equal(t, 120.5, totals[1].PreviousCost)
+	assert.Equal(t, 0.0, totals[1].CurrentCost)
+}
+
+func TestGroupTotals(t *testing.T) {
+	projects := []ProjectCostComparison{{ProjectID: "a", CurrentCost: 1}}
+	assert.Equal(t, projects, GroupTotals(projects))
+
+	rows := []ProjectCostComparison{
+		{Group: "team-a", ProjectID: "a", PreviousCost: 10, CurrentCost: 20, Currency: "USD"},
+		{Group: "team-b", ProjectID: "b", PreviousCost: 5, CurrentCost: 50, Currency: "USD"},
+		{Group: "team-a", ProjectID: "c", PreviousCost: 10, CurrentCost: 10, Currency: "USD"},
+	}
+	assert.Equal(t, []ProjectCostComparison{
+		{Group: "team-b", PreviousCost: 5, CurrentCost: 50, Delta: 45, DeltaPercent: 900, Currency: "USD"},
+		{Group: "team-a", PreviousCost: 20, CurrentCost: 30, Delta: 10, DeltaPercent: 50, Currency: "USD"},
+	}, GroupTotals(rows))
+}
+
+func TestUsageSheets(t *testing.T) {
+	headers := []string{"项目id", "项目名称"}
+	projects := []ProjectCostComparison{{ProjectID: "a"}}
+	sheets := usageSheets(projects, headers, nil)
+	require.Len(t, sheets, 1)
+	assert.Equal(t, headers, sheets[0].headers)
+
+	rows := []ProjectCostComparison{
+		{Group: "team/a", ProjectID: "a", CurrentCost: 20},
+		{Group: "team?a", ProjectID: "b", CurrentCost: 10},
+	}
+	sheets = usageSheets(rows, headers, nil)
+	require.Len(t, sheets, 3)
+	assert.Equal(t, "分组", sheets[0].headers[0])
+	assert.Len(t, sheets[0].rows, 2)
+	// 非法字符替换后重名的工作表加序号区分
+	assert.Equal(t, "team_a", sheets[1].name)
+	assert.Equal(t, "team_a~2", sheets[2].name)
+	assert.Equal(t, "a", sheets[1].rows[0][0])
+
+	// Excel 工作表名不区分大小写，与汇总表 Sheet1 同名的分组也要改名
+	sheets = usageSheets([]ProjectCostComparison{{Group: "sheet1", ProjectID: "a", CurrentCost: 10}}, headers, nil)
+	require.Len(t, sheets, 2)
+	assert.Equal(t, "Sheet1", sheets[0].name)
+	assert.Equal(t, "sheet1~2", sheets[1].name)
+}
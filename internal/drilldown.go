@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"context"
+	"math"
+	"sort"
+)
+
+// DailyDrillDown 为日用量异常项目查询费用变化最大的服务和 SKU
+func (u *UsageCheckCase) DailyDrillDown(ctx context.Context, anomalies []Anomaly, topN int) error {
+	return u.DrillDown(ctx, u.calendar.DailyWindow(u.calendar.Now()), anomalies, topN)
+}
+
+// DrillDown 在 w 的两个周期内查询异常项目的服务/SKU 明细，结果写入 anomalies[i].DrillDown
+func (u *UsageCheckCase) DrillDown(ctx context.Context, w Window, anomalies []Anomaly, topN int) error {
+	var projectIDs []string
+	for _, a := range anomalies {
+		// 分组汇总行没有对应的单个项目
+		if a.ProjectID != "" {
+			projectIDs = append(projectIDs, a.ProjectID)
+		}
+	}
+	if len(projectIDs) == 0 {
+		return nil
+	}
+	rows, err := u.source.CostBreakdown(ctx, w, projectIDs)
+	if err != nil {
+		return err
+	}
+	drillDowns := topBreakdowns(rows, topN)
+	for i := range anomalies {
+		if anomalies[i].ProjectID != "" {
+			anomalies[i].DrillDown = drillDowns[anomalies[i].ProjectID]
+		}
+	}
+	return nil
+}
+
+// topBreakdowns 按项目分别汇总服务和 SKU，各取变化金额绝对值最大的 topN 个
+func topBreakdowns(rows []CostBreakdown, topN int) map[string]*ProjectDrillDown {
+	type key struct{ projectID, service string }
+	services := make(map[key]*CostBreakdown)
+	var serviceOrder []key
+	res := make(map[string]*ProjectDrillDown)
+	for _, row := range rows {
+		d, ok := res[row.ProjectID]
+		if !ok {
+			d = &ProjectDrillDown{}
+			res[row.ProjectID] = d
+		}
+		d.SKUs = append(d.SKUs, row)
+
+		k := key{row.ProjectID, row.Service}
+		s, ok := services[k]
+		if !ok {
+			s = &CostBreakdown{ProjectID: row.ProjectID, Service: row.Service}
+			services[k] = s
+			serviceOrder = append(serviceOrder, k)
+		}
+		s.PreviousCost += row.PreviousCost
+		s.CurrentCost += row.CurrentCost
+		s.Delta += row.Delta
+	}
+	for _, k := range serviceOrder {
+		res[k.projectID].Services = append(res[k.projectID].Services, *services[k])
+	}
+
+	for _, d := range res {
+		d.Services = topByDelta(d.Services, topN)
+		d.SKUs = topByDelta(d.SKUs, topN)
+	}
+	return res
+}
+
+func topByDelta(rows []CostBreakdown, topN int) []CostBreakdown {
+	sort.SliceStable(rows, func(i, j int) bool {
+		return math.Abs(rows[i].Delta) > math.Abs(rows[j].Delta)
+	})
+	if topN > 0 && len(rows) > topN {
+		rows = rows[:topN]
+	}
+	return rows
+}
@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func breakdown(projectID, service, sku string, prev, cur float64) CostBreakdown {
+	return CostBreakdown{ProjectID: projectID, Service: service, SKU: sku, PreviousCost: prev, CurrentCost: cur, Delta: cur - prev}
+}
+
+func TestDailyDrillDown(t *testing.T) {
+	source := &MemorySource{Breakdowns: []CostBreakdown{
+		breakdown("spike", "Compute Engine", "N2 Core", 50, 150),
+		breakdown("spike", "Compute Engine", "N2 Ram", 20, 60),
+		breakdown("spike", "Cloud Storage", "Standard", 30, 25),
+		breakdown("spike", "BigQuery", "Analysis", 0, 10),
+		breakdown("steady", "Compute Engine", "N2 Core", 100, 100),
+	}}
+	checkCase, err := NewUsageCheckCase(source, &config.Config{}, nil)
+	require.NoError(t, err)
+
+	anomalies := []Anomaly{{ProjectCostComparison: comparison("spike", 100, 245)}, {ProjectCostComparison: comparison("missing", 100, 200)}}
+	require.NoError(t, checkCase.DailyDrillDown(context.Background(), anomalies, 2))
+
+	require.NotNil(t, anomalies[0].DrillDown)
+	assert.Equal(t, []CostBreakdown{
+		{ProjectID: "spike", Service: "Compute Engine", PreviousCost: 70, CurrentCost: 210, Delta: 140},
+		{ProjectID: "spike", Service: "BigQuery", CurrentCost: 10, Delta: 10},
+	}, anomalies[0].DrillDown.Services)
+	assert.Equal(t, []CostBreakdown{
+		breakdown("spike", "Compute Engine", "N2 Core", 50, 150),
+		breakdown("spike", "Compute Engine", "N2 Ram", 20, 60),
+	}, anomalies[0].DrillDown.SKUs)
+	assert.Nil(t, anomalies[1].DrillDown)
+
+	msg := formatRowsToString(anomalies[:1])
+	assert.Contains(t, msg, "\t  服务 Compute Engine: 70.00 -> 210.00 (+140.00)\n")
+	assert.Contains(t, msg, "\t  SKU Compute Engine / N2 Ram: 20.00 -> 60.00 (+40.00)\n")
+}
+
+func TestFileSourceCostBreakdown(t *testing.T) {
+	source, err := NewFileSource(Dimension{}, "testdata/billing_export.csv")
+	require.NoError(t, err)
+
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+	w := Window{PrevStart: day(4), PrevEnd: day(5), CurStart: day(5), CurEnd: day(6)}
+	rows, err := source.CostBreakdown(context.Background(), w, []string{"prod-app"})
+	require.NoError(t, err)
+	assert.Equal(t, []CostBreakdown{
+		breakdown("prod-app", "Compute Engine", "N2 Instance Core", 120.5, 0),
+		breakdown("prod-app", "Cloud Storage", "Standard Storage", 0, 30),
+	}, rows)
+}
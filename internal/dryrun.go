@@ -0,0 +1,23 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// renderFile dry run 时将本应发送的内容写入 dir 中的新文件，pattern 同 os.CreateTemp，返回文件路径
+func renderFile(dir, pattern string, write func(w io.Writer) error) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("error creating dry run directory: %v", err)
+	}
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return "", fmt.Errorf("error creating dry run file: %v", err)
+	}
+	defer f.Close()
+	if err := write(f); err != nil {
+		return "", fmt.Errorf("error writing dry run file: %v", err)
+	}
+	return f.Name(), nil
+}
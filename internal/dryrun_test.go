@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+	outputDir := filepath.Join(t.TempDir(), "reports")
+	dryRunDir := filepath.Join(t.TempDir(), "dry-run")
+	storageCase, err := NewLocalStorageCase(outputDir, FixedClock(time.Date(2024, 3, 11, 9, 0, 0, 0, time.UTC)))
+	require.NoError(t, err)
+	require.NoError(t, storageCase.SaveBudgetState(ctx, "2024-03", BudgetState{"web": &BudgetAlertState{Thresholds: []float64{50}}}))
+	storageCase = storageCase.WithDryRun(dryRunDir)
+
+	// 报表和预算状态写入 dry run 目录，读取时仍能读到原有状态
+	require.NoError(t, storageCase.StoreWeekUsage(ctx, []ProjectCostComparison{comparison("web", 100, 150)}))
+	assert.FileExists(t, filepath.Join(dryRunDir, "week_usage_2024-03-11.xlsx"))
+	assert.NoFileExists(t, filepath.Join(outputDir, "week_usage_2024-03-11.xlsx"))
+	state, err := storageCase.LoadBudgetState(ctx, "2024-03")
+	require.NoError(t, err)
+	assert.Equal(t, []float64{50}, state["web"].Thresholds)
+	state["web"].Thresholds = append(state["web"].Thresholds, 80)
+	require.NoError(t, storageCase.SaveBudgetState(ctx, "2024-03", state))
+	content, err := os.ReadFile(filepath.Join(outputDir, "budget_state_2024-03.json"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"web": {"thresholds": [50], "burnRate": false}}`, string(content))
+
+	// 邮件附带 dry run 中生成的报表
+	emailCase := NewEmailUseCase(storageCase, "smtp.invalid", 465, "billing@example.com", "").WithClock(storageCase.clock).WithDryRun(dryRunDir)
+	require.NoError(t, emailCase.SendWeekUsageReport(ctx, "finance@example.com"))
+	emails, err := filepath.Glob(filepath.Join(dryRunDir, "email_*.eml"))
+	require.NoError(t, err)
+	require.Len(t, emails, 1)
+	content, err = os.ReadFile(emails[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "Subject: Weekly Usage Report 2024-03-11")
+	assert.Contains(t, string(content), "To: finance@example.com")
+	assert.Contains(t, string(content), `filename="week_usage_2024-03-11.xlsx"`)
+
+	webHook := NewWebHookUserCaseWithDingTalk("http://127.0.0.1:0/robot").WithKeyWord("billing").WithDryRun(dryRunDir)
+	require.NoError(t, webHook.Notify(ctx, Alert{Title: "daily Warning", Anomalies: []Anomaly{{ProjectCostComparison: comparison("web", 100, 150)}}}))
+	messages, err := filepath.Glob(filepath.Join(dryRunDir, "dingtalk_*.json"))
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	content, err = os.ReadFile(messages[0])
+	require.NoError(t, err)
+	var message struct {
+		MsgType string `json:"msgtype"`
+		Text    struct {
+			Content string `json:"content"`
+		} `json:"text"`
+	}
+	require.NoError(t, json.Unmarshal(content, &message))
+	assert.Equal(t, "text", message.MsgType)
+	assert.Contains(t, message.Text.Content, "daily Warning\n billing: \n")
+	assert.Contains(t, message.Text.Content, "web")
+}
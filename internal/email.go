@@ -6,7 +6,6 @@ import (
 	"gopkg.in/gomail.v2"
 	"io"
 	"log"
-	"time"
 )
 
 type EmailUseCase struct {
@@ -15,6 +14,10 @@ type EmailUseCase struct {
 	smtpPort     int
 	smtpUsername string
 	smtpPassword string
+	// clock 决定附件报表和邮件标题中的日期
+	clock Clock
+	// dryRunDir 不为空时邮件以 MIME 格式写入该目录，不发送
+	dryRunDir string
 }
 
 func NewEmailUseCase(storageCase *StorageCase, smtpHost string, smtpPort int, smtpUsername, smtpPassword string) *EmailUseCase {
@@ -24,9 +27,24 @@ func NewEmailUseCase(storageCase *StorageCase, smtpHost string, smtpPort int, sm
 		smtpPort:     smtpPort,
 		smtpUsername: smtpUsername,
 		smtpPassword: smtpPassword,
+		clock:        SystemClock,
 	}
 }
 
+// WithClock 返回按 clock 的日期发送报表的副本
+func (e *EmailUseCase) WithClock(clock Clock) *EmailUseCase {
+	copied := *e
+	copied.clock = clock
+	return &copied
+}
+
+// WithDryRun 返回不发送邮件的副本，邮件写入 dir
+func (e *EmailUseCase) WithDryRun(dir string) *EmailUseCase {
+	copied := *e
+	copied.dryRunDir = dir
+	return &copied
+}
+
 func (e *EmailUseCase) SendExcelAttachment(ctx context.Context, fileName, recipient, subject, body string) error {
 	// 从 StorageCase 获取 Excel 文件
 	content, err := e.storageCase.GetExcelFile(ctx, fileName)
@@ -45,6 +63,18 @@ func (e *EmailUseCase) SendExcelAttachment(ctx context.Context, fileName, recipi
 		return err
 	}))
 
+	if e.dryRunDir != "" {
+		path, err := renderFile(e.dryRunDir, "email_*.eml", func(w io.Writer) error {
+			_, err := m.WriteTo(w)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("dry run: email %q to %s written to %s", subject, recipient, path)
+		return nil
+	}
+
 	// 发送邮件
 	d := gomail.NewDialer(e.smtpHost, e.smtpPort, e.smtpUsername, e.smtpPassword)
 	if err := d.DialAndSend(m); err != nil {
@@ -56,22 +86,22 @@ func (e *EmailUseCase) SendExcelAttachment(ctx context.Context, fileName, recipi
 }
 
 func (e *EmailUseCase) SendWeekUsageReport(ctx context.Context, recipient string) error {
-	fileName := fmt.Sprintf("week_usage_%s.xlsx", time.Now().Format("2006-01-02"))
-	subject := "Weekly Usage Report"
+	fileName := reportFileName("week", e.clock)
+	subject := fmt.Sprintf("Weekly Usage Report %s", reportDate(e.clock))
 	body := "Please find attached the weekly usage report."
 	return e.SendExcelAttachment(ctx, fileName, recipient, subject, body)
 }
 
 func (e *EmailUseCase) SendMonthUsageReport(ctx context.Context, recipient string) error {
-	fileName := fmt.Sprintf("month_usage_%s.xlsx", time.Now().Format("2006-01-02"))
-	subject := "Monthly Usage Report"
+	fileName := reportFileName("month", e.clock)
+	subject := fmt.Sprintf("Monthly Usage Report %s", reportDate(e.clock))
 	body := "Please find attached the monthly usage report."
 	return e.SendExcelAttachment(ctx, fileName, recipient, subject, body)
 }
 
 func (e *EmailUseCase) SendDailyUsageReport(ctx context.Context, recipient string) error {
-	fileName := fmt.Sprintf("daily_usage_%s.xlsx", time.Now().Format("2006-01-02"))
-	subject := "Daily Usage Report"
+	fileName := reportFileName("daily", e.clock)
+	subject := fmt.Sprintf("Daily Usage Report %s", reportDate(e.clock))
 	body := "Please find attached the daily usage report."
 	return e.SendExcelAttachment(ctx, fileName, recipient, subject, body)
 }
@@ -0,0 +1,245 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// feishuTemplates 卡片标题颜色
+var feishuTemplates = map[Severity]string{
+	SeverityInfo:     "green",
+	SeverityWarning:  "orange",
+	SeverityCritical: "red",
+}
+
+// FeiShuNotifier 飞书自定义机器人，发送消息卡片
+type FeiShuNotifier struct {
+	url string
+	// secret 签名校验密钥，为空时不签名
+	secret string
+	// clock 签名使用的时间戳
+	clock Clock
+	// dryRunDir 不为空时消息的请求体写入该目录，不发送
+	dryRunDir string
+}
+
+func NewFeiShuNotifier(url string) *FeiShuNotifier {
+	return &FeiShuNotifier{url: url, clock: SystemClock}
+}
+
+// newFeiShuNotifier 飞书机器人渠道
+func newFeiShuNotifier(cfg config.Notifier, dryRunDir string) (Notifier, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("url is required")
+	}
+	return NewFeiShuNotifier(cfg.URL).WithSecret(cfg.Secret).WithDryRun(dryRunDir), nil
+}
+
+// WithSecret 使用签名校验密钥签名
+func (n *FeiShuNotifier) WithSecret(secret string) *FeiShuNotifier {
+	copied := *n
+	copied.secret = secret
+	return &copied
+}
+
+// WithClock 签名使用 clock 的时间戳
+func (n *FeiShuNotifier) WithClock(clock Clock) *FeiShuNotifier {
+	copied := *n
+	copied.clock = clock
+	return &copied
+}
+
+// WithDryRun 返回不发送消息的副本，请求体写入 dir
+func (n *FeiShuNotifier) WithDryRun(dir string) *FeiShuNotifier {
+	copied := *n
+	copied.dryRunDir = dir
+	return &copied
+}
+
+func (n *FeiShuNotifier) Notify(ctx context.Context, alert Alert) error {
+	message, err := n.message(alert)
+	if err != nil {
+		return err
+	}
+	return postWebhook(ctx, "feishu", n.url, alert.Title, message, n.dryRunDir)
+}
+
+// message 卡片消息的请求体，设置了密钥时附带时间戳和签名
+func (n *FeiShuNotifier) message(alert Alert) (map[string]interface{}, error) {
+	message := map[string]interface{}{
+		"msg_type": "interactive",
+		"card":     feishuCard(alert),
+	}
+	if n.secret != "" {
+		timestamp := strconv.FormatInt(n.clock.Now().Unix(), 10)
+		sign, err := feishuSign(timestamp, n.secret)
+		if err != nil {
+			return nil, err
+		}
+		message["timestamp"] = timestamp
+		message["sign"] = sign
+	}
+	return message, nil
+}
+
+// feishuSign 飞书签名: 以 timestamp + "\n" + secret 为密钥对空字符串计算 HmacSHA256 后 Base64 编码
+func feishuSign(timestamp, secret string) (string, error) {
+	mac := hmac.New(sha256.New, []byte(timestamp+"\n"+secret))
+	if _, err := mac.Write(nil); err != nil {
+		return "", fmt.Errorf("error signing feishu message: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// feishuColumn 表格的一列
+type feishuColumn struct {
+	name        string
+	displayName string
+	dataType    string
+}
+
+// feishuCard 消息卡片: 标题按严重程度着色，明细为 项目/上期/本期/变化 表格，
+// 异常项目的触发规则、抵扣和服务/SKU 明细在表格后按项目列出，有报表时附查看按钮
+func feishuCard(alert Alert) map[string]interface{} {
+	var elements []interface{}
+	if alert.Message != "" {
+		elements = append(elements, feishuMarkdown(alert.Message))
+	}
+
+	columns := func(previous, current string) []feishuColumn {
+		return []feishuColumn{
+			{name: "project", displayName: "项目", dataType: "text"},
+			{name: "previous", displayName: previous, dataType: "number"},
+			{name: "current", displayName: current, dataType: "number"},
+			{name: "delta", displayName: "变化", dataType: "text"},
+		}
+	}
+	row := func(project string, previous, current, delta, percent float64) map[string]interface{} {
+		return map[string]interface{}{
+			"project":  project,
+			"previous": previous,
+			"current":  current,
+			"delta":    fmt.Sprintf("%+.2f (%.1f%%)", delta, percent),
+		}
+	}
+	if len(alert.Anomalies) > 0 {
+		var rows []map[string]interface{}
+		for _, a := range alert.Anomalies {
+			rows = append(rows, row(a.Key(), a.PreviousCost, a.CurrentCost, a.Delta, a.DeltaPercent))
+		}
+		elements = append(elements, feishuTable(columns("上期", "本期"), rows))
+		for _, a := range alert.Anomalies {
+			if detail := feishuAnomalyDetail(a); detail != "" {
+				elements = append(elements, feishuMarkdown(detail))
+			}
+		}
+	}
+	if len(alert.Baseline) > 0 {
+		var rows []map[string]interface{}
+		for _, a := range alert.Baseline {
+			percent := 0.0
+			if a.Expected != 0 {
+				percent = (a.Actual - a.Expected) / a.Expected * 100
+			}
+			rows = append(rows, row(a.ProjectID, a.Expected, a.Actual, a.Actual-a.Expected, percent))
+		}
+		elements = append(elements, feishuTable(columns("预期", "实际"), rows))
+	}
+	if forecasts := visibleForecasts(alert.Forecasts, alert.ForecastTopN); len(forecasts) > 0 {
+		var rows []map[string]interface{}
+		for _, f := range forecasts {
+			rows = append(rows, row(f.ProjectID, f.LastMonthCost, f.Forecast, f.ForecastDelta, f.ForecastDeltaPercent))
+		}
+		elements = append(elements, feishuTable(columns("上月", "预测本月"), rows))
+	}
+	if len(alert.Budgets) > 0 {
+		var lines []string
+		for _, b := range alert.Budgets {
+			line := fmt.Sprintf("**%s** 预算 %.2f，本月已用 %.2f (%.1f%%)", b.Budget, b.Amount, b.Spent, b.Percent)
+			if len(b.Thresholds) > 0 {
+				line += "，已超过 " + formatThresholds(b.Thresholds)
+			}
+			if !b.ExhaustDate.IsZero() {
+				line += fmt.Sprintf("，日均消耗 %.2f，预计 %s 耗尽预算", b.BurnRate, b.ExhaustDate.Format("2006-01-02"))
+			}
+			lines = append(lines, line)
+		}
+		elements = append(elements, feishuMarkdown(strings.Join(lines, "\n")))
+	}
+	if alert.ReportURL != "" {
+		elements = append(elements, map[string]interface{}{
+			"tag": "action",
+			"actions": []interface{}{map[string]interface{}{
+				"tag":  "button",
+				"text": map[string]string{"tag": "plain_text", "content": "查看 Excel 报表"},
+				"type": "primary",
+				"url":  alert.ReportURL,
+			}},
+		})
+	}
+
+	return map[string]interface{}{
+		"config": map[string]interface{}{"wide_screen_mode": true},
+		"header": map[string]interface{}{
+			"title":    map[string]string{"tag": "plain_text", "content": alert.Title},
+			"template": feishuTemplates[alert.Severity()],
+		},
+		"elements": elements,
+	}
+}
+
+// feishuAnomalyDetail 异常项目的触发规则、抵扣和服务/SKU 明细，都没有时为空
+func feishuAnomalyDetail(a Anomaly) string {
+	var lines []string
+	if len(a.Rules) > 0 {
+		lines = append(lines, "触发规则: "+strings.Join(a.Rules, ", "))
+	}
+	if a.PreviousCredits != 0 || a.CurrentCredits != 0 {
+		lines = append(lines, fmt.Sprintf("抵扣: %.2f -> %.2f", a.PreviousCredits, a.CurrentCredits))
+	}
+	if a.DrillDown != nil {
+		for _, b := range a.DrillDown.Services {
+			lines = append(lines, fmt.Sprintf("服务 %s: %.2f -> %.2f (%+.2f)", b.Service, b.PreviousCost, b.CurrentCost, b.Delta))
+		}
+		for _, b := range a.DrillDown.SKUs {
+			lines = append(lines, fmt.Sprintf("SKU %s / %s: %.2f -> %.2f (%+.2f)", b.Service, b.SKU, b.PreviousCost, b.CurrentCost, b.Delta))
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return "**" + a.Key() + "**\n" + strings.Join(lines, "\n")
+}
+
+func feishuMarkdown(content string) map[string]interface{} {
+	return map[string]interface{}{
+		"tag":  "div",
+		"text": map[string]string{"tag": "lark_md", "content": content},
+	}
+}
+
+func feishuTable(columns []feishuColumn, rows []map[string]interface{}) map[string]interface{} {
+	var cols []interface{}
+	for _, c := range columns {
+		col := map[string]interface{}{"name": c.name, "display_name": c.displayName, "data_type": c.dataType}
+		if c.dataType == "number" {
+			col["format"] = map[string]int{"precision": 2}
+		}
+		cols = append(cols, col)
+	}
+	return map[string]interface{}{
+		"tag":          "table",
+		"page_size":    10,
+		"row_height":   "low",
+		"header_style": map[string]interface{}{"bold": true},
+		"columns":      cols,
+		"rows":         rows,
+	}
+}
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeiShuNotifier(t *testing.T) {
+	ctx := context.Background()
+	var received map[string]interface{}
+	robot := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		fmt.Fprint(w, `{"code":0,"msg":"success"}`)
+	}))
+	defer robot.Close()
+
+	notifiers, err := NewNotifierRegistry().New([]config.Notifier{{Type: "feishu", URL: robot.URL}}, "")
+	require.NoError(t, err)
+	alert := Alert{
+		Title:     "daily Warning",
+		Anomalies: []Anomaly{{ProjectCostComparison: comparison("web", 100, 150)}},
+		ReportURL: "https://storage.cloud.google.com/billing-reports/daily_usage_2024-03-11.xlsx",
+	}
+	require.NoError(t, notifiers.Notify(ctx, alert))
+	assert.Equal(t, "interactive", received["msg_type"])
+	assert.NotContains(t, received, "sign")
+
+	card := received["card"].(map[string]interface{})
+	header := card["header"].(map[string]interface{})
+	assert.Equal(t, "daily Warning", header["title"].(map[string]interface{})["content"])
+	assert.Equal(t, "orange", header["template"])
+	elements := card["elements"].([]interface{})
+	require.Len(t, elements, 2)
+	table := elements[0].(map[string]interface{})
+	assert.Equal(t, "table", table["tag"])
+	var columns []string
+	for _, column := range table["columns"].([]interface{}) {
+		columns = append(columns, column.(map[string]interface{})["display_name"].(string))
+	}
+	assert.Equal(t, []string{"项目", "上期", "本期", "变化"}, columns)
+	assert.Equal(t, []interface{}{map[string]interface{}{"project": "web", "previous": 100.0, "current": 150.0, "delta": "+50.00 (50.0%)"}}, table["rows"])
+	button := elements[1].(map[string]interface{})["actions"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, alert.ReportURL, button["url"])
+
+	// 异常项目的触发规则、抵扣和明细在表格后列出，没有这些信息的项目不列出
+	flagged := Anomaly{ProjectCostComparison: comparison("api", 100, 180), Rules: []string{"daily-relative-30%"}}
+	flagged.PreviousCredits, flagged.CurrentCredits = -5, -8
+	flagged.DrillDown = &ProjectDrillDown{
+		Services: []CostBreakdown{{Service: "Compute Engine", PreviousCost: 60, CurrentCost: 130, Delta: 70}},
+		SKUs:     []CostBreakdown{{Service: "Compute Engine", SKU: "N2 Instance Core", PreviousCost: 40, CurrentCost: 100, Delta: 60}},
+	}
+	detailed := feishuCard(Alert{Title: "daily Warning", Anomalies: []Anomaly{alert.Anomalies[0], flagged}})["elements"].([]interface{})
+	require.Len(t, detailed, 2)
+	assert.Equal(t, feishuMarkdown("**api**\n触发规则: daily-relative-30%\n抵扣: -5.00 -> -8.00\n"+
+		"服务 Compute Engine: 60.00 -> 130.00 (+70.00)\nSKU Compute Engine / N2 Instance Core: 40.00 -> 100.00 (+60.00)"), detailed[1])
+
+	// 标题颜色按严重程度
+	assert.Equal(t, "green", feishuCard(Alert{Title: "日用量无异常"})["header"].(map[string]interface{})["template"])
+	critical := Alert{Title: "预算报警", Budgets: []BudgetAlert{{Budget: "prod", Amount: 100, Spent: 120, Percent: 120, Thresholds: []float64{100, 120}}}}
+	assert.Equal(t, SeverityCritical, critical.Severity())
+	assert.Equal(t, "red", feishuCard(critical)["header"].(map[string]interface{})["template"])
+
+	// 签名校验
+	now := time.Date(2024, 3, 11, 1, 0, 0, 0, time.UTC)
+	message, err := NewFeiShuNotifier(robot.URL).WithSecret("secret").WithClock(FixedClock(now)).message(alert)
+	require.NoError(t, err)
+	timestamp := fmt.Sprint(now.Unix())
+	mac := hmac.New(sha256.New, []byte(timestamp+"\nsecret"))
+	assert.Equal(t, timestamp, message["timestamp"])
+	assert.Equal(t, base64.StdEncoding.EncodeToString(mac.Sum(nil)), message["sign"])
+
+	// 飞书在 code 中返回签名错误
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"code":19021,"msg":"sign match fail or timestamp is not within one hour from current time"}`)
+	}))
+	defer rejecting.Close()
+	err = NewFeiShuNotifier(rejecting.URL).WithSecret("wrong").Notify(ctx, alert)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "19021")
+}
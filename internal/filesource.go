@@ -0,0 +1,500 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BillingRecord 账单导出表中的一行
+type BillingRecord struct {
+	BillingAccountID string
+	ProjectID        string
+	ProjectName      string
+	ProjectLabels    map[string]string
+	Service          string
+	SKU              string
+	Region           string
+	Cost             float64
+	Currency         string
+	UsageStartTime   time.Time
+	PartitionTime    time.Time
+	// InvoiceMonth 发票月，如 202403
+	InvoiceMonth string
+	Labels       map[string]string
+	Credits      []Credit
+}
+
+// Credit 账单行上的抵扣项
+type Credit struct {
+	Name   string  `json:"name"`
+	Amount float64 `json:"amount"`
+	Type   string  `json:"type"`
+}
+
+// partitionTime 与 _PARTITIONTIME 对应，导出文件缺少该列时按用量开始时间所在日计算
+func (r BillingRecord) partitionTime() time.Time {
+	if !r.PartitionTime.IsZero() {
+		return r.PartitionTime
+	}
+	t := r.UsageStartTime.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// invoiceMonth 导出文件缺少 invoice.month 时按分区所在月计算
+func (r BillingRecord) invoiceMonth() string {
+	if r.InvoiceMonth != "" {
+		return r.InvoiceMonth
+	}
+	return invoiceMonth(r.partitionTime())
+}
+
+// FileSource 从本地账单导出文件(CSV / JSONL)读取数据，计算方式与 BigQueryUserCase 一致
+type FileSource struct {
+	records   []BillingRecord
+	dimension Dimension
+	costMode  string
+	calendar  *Calendar
+}
+
+var _ BillingSource = (*FileSource)(nil)
+
+// NewFileSource dimension 为日/周/月用量的分组维度
+func NewFileSource(dimension Dimension, paths ...string) (*FileSource, error) {
+	var records []BillingRecord
+	for _, path := range paths {
+		rs, err := LoadBillingExport(path)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rs...)
+	}
+	return NewFileSourceFromRecords(dimension, records), nil
+}
+
+func NewFileSourceFromRecords(dimension Dimension, records []BillingRecord) *FileSource {
+	calendar, _ := NewCalendar("", "")
+	return &FileSource{records: records, dimension: dimension, calendar: calendar}
+}
+
+// WithCostMode 费用按 gross(默认) 或 net 计算，与 QueryBuilder.WithCostMode 一致
+func (f *FileSource) WithCostMode(mode string) *FileSource {
+	copied := *f
+	copied.costMode = mode
+	return &copied
+}
+
+// WithCalendar 日/周/月用量按 calendar 的时区和每周第一天划分周期，默认使用本地时区
+func (f *FileSource) WithCalendar(calendar *Calendar) *FileSource {
+	copied := *f
+	copied.calendar = calendar
+	return &copied
+}
+
+// cost 按费用口径计算一行账单的费用
+func (f *FileSource) cost(r BillingRecord) float64 {
+	if f.costMode == costModeNet {
+		return r.Cost + creditSum(r.Credits)
+	}
+	return r.Cost
+}
+
+func (f *FileSource) DailyUsage(ctx context.Context) ([]ProjectCostComparison, error) {
+	return compareRecords(f.records, f.dimension, f.costMode, f.calendar.DailyWindow(f.calendar.Now())), nil
+}
+
+func (f *FileSource) WeekUsage(ctx context.Context) ([]ProjectCostComparison, error) {
+	return compareRecords(f.records, f.dimension, f.costMode, f.calendar.WeekWindow(f.calendar.Now())), nil
+}
+
+func (f *FileSource) MonthUsage(ctx context.Context) ([]ProjectCostComparison, error) {
+	return compareRecords(f.records, f.dimension, f.costMode, f.calendar.MonthWindow(f.calendar.Now())), nil
+}
+
+func (f *FileSource) InvoiceMonthUsage(ctx context.Context) ([]ProjectCostComparison, error) {
+	return compareInvoiceMonths(f.records, f.dimension, f.costMode, f.calendar.MonthWindow(f.calendar.Now())), nil
+}
+
+func (f *FileSource) DailyCosts(ctx context.Context, start, end time.Time) ([]ProjectDailyCost, error) {
+	type key struct {
+		projectID string
+		day       time.Time
+	}
+	byDay := make(map[key]*ProjectDailyCost)
+	var res []*ProjectDailyCost
+	for _, r := range f.records {
+		day := r.partitionTime()
+		if day.Before(start) || !day.Before(end) {
+			continue
+		}
+		k := key{r.ProjectID, day}
+		d, ok := byDay[k]
+		if !ok {
+			d = &ProjectDailyCost{ProjectID: r.ProjectID, ProjectName: r.ProjectName, Day: day, Currency: r.Currency, ProjectLabels: r.ProjectLabels}
+			byDay[k] = d
+			res = append(res, d)
+		}
+		d.Cost += f.cost(r)
+	}
+
+	costs := make([]ProjectDailyCost, 0, len(res))
+	for _, d := range res {
+		costs = append(costs, *d)
+	}
+	sort.SliceStable(costs, func(i, j int) bool {
+		if costs[i].ProjectID != costs[j].ProjectID {
+			return costs[i].ProjectID < costs[j].ProjectID
+		}
+		return costs[i].Day.Before(costs[j].Day)
+	})
+	return costs, nil
+}
+
+func (f *FileSource) CostBreakdown(ctx context.Context, w Window, projectIDs []string) ([]CostBreakdown, error) {
+	wanted := make(map[string]bool, len(projectIDs))
+	for _, projectID := range projectIDs {
+		wanted[projectID] = true
+	}
+	type key struct{ projectID, service, sku string }
+	bySKU := make(map[key]*CostBreakdown)
+	var order []key
+	for _, r := range f.records {
+		if !wanted[r.ProjectID] {
+			continue
+		}
+		prev, cur := w.Contains(r.partitionTime())
+		if !prev && !cur {
+			continue
+		}
+		k := key{r.ProjectID, r.Service, r.SKU}
+		b, ok := bySKU[k]
+		if !ok {
+			b = &CostBreakdown{ProjectID: r.ProjectID, Service: r.Service, SKU: r.SKU}
+			bySKU[k] = b
+			order = append(order, k)
+		}
+		if prev {
+			b.PreviousCost += f.cost(r)
+		}
+		if cur {
+			b.CurrentCost += f.cost(r)
+		}
+	}
+
+	res := make([]CostBreakdown, 0, len(order))
+	for _, k := range order {
+		b := bySKU[k]
+		b.Delta = b.CurrentCost - b.PreviousCost
+		res = append(res, *b)
+	}
+	return res, nil
+}
+
+// compareRecords 按分组和项目汇总两个周期的费用与抵扣，costMode 为 net 时费用包含抵扣
+func compareRecords(records []BillingRecord, dimension Dimension, costMode string, w Window) []ProjectCostComparison {
+	return aggregateRecords(records, dimension, costMode, func(r BillingRecord) (prev, cur, adjustment bool) {
+		prev, cur = w.Contains(r.partitionTime())
+		return prev, cur, false
+	})
+}
+
+// compareInvoiceMonths 与 QueryBuilder.InvoiceMonthQuery 一致，按发票月划分两期
+func compareInvoiceMonths(records []BillingRecord, dimension Dimension, costMode string, w Window) []ProjectCostComparison {
+	prevMonth, curMonth := invoiceMonth(w.PrevStart), invoiceMonth(w.CurStart)
+	return aggregateRecords(records, dimension, costMode, func(r BillingRecord) (prev, cur, adjustment bool) {
+		month := r.invoiceMonth()
+		prev, cur = month == prevMonth, month == curMonth
+		start, err := invoiceMonthStart(month)
+		adjustment = err == nil && r.UsageStartTime.Before(start)
+		return prev, cur, adjustment
+	})
+}
+
+// periodClassifier 判断一行账单属于哪一期，以及是否为延迟到账的调整
+type periodClassifier func(r BillingRecord) (prev, cur, adjustment bool)
+
+func aggregateRecords(records []BillingRecord, dimension Dimension, costMode string, classify periodClassifier) []ProjectCostComparison {
+	type key struct{ group, projectID string }
+	byProject := make(map[key]*ProjectCostComparison)
+	var order []key
+	for _, r := range records {
+		prev, cur, adjustment := classify(r)
+		if !prev && !cur {
+			continue
+		}
+		k := key{dimension.recordGroup(r), r.ProjectID}
+		c, ok := byProject[k]
+		if !ok {
+			c = &ProjectCostComparison{Group: k.group, ProjectID: r.ProjectID}
+			byProject[k] = c
+			order = append(order, k)
+		}
+		if c.ProjectName == "" {
+			c.ProjectName = r.ProjectName
+		}
+		if c.Currency == "" {
+			c.Currency = r.Currency
+		}
+		if c.ProjectLabels == nil {
+			c.ProjectLabels = r.ProjectLabels
+		}
+		if prev {
+			c.PreviousCost += r.Cost
+		}
+		if cur {
+			c.CurrentCost += r.Cost
+		}
+		c.addCredits(r.Credits, prev, cur)
+		// 与费用一样先按 gross 统计，再由 withCostMode 换算
+		if adjustment {
+			credits := creditSum(r.Credits)
+			if prev {
+				c.PreviousAdjustments += r.Cost
+				c.PreviousAdjustmentCredits += credits
+			}
+			if cur {
+				c.CurrentAdjustments += r.Cost
+				c.CurrentAdjustmentCredits += credits
+			}
+		}
+	}
+
+	var rows []ProjectCostComparison
+	for _, k := range order {
+		c := byProject[k]
+		sortCredits(c.Credits)
+		c.computeDelta()
+		rows = append(rows, c.withCostMode(costMode))
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].Delta > rows[j].Delta
+	})
+	return rows
+}
+
+// LoadBillingExport 按扩展名读取 .csv 或 .jsonl/.ndjson/.json 格式的账单导出文件
+func LoadBillingExport(path string) ([]BillingRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		records, err := parseBillingCSV(file)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", path, err)
+		}
+		return records, nil
+	case ".jsonl", ".ndjson", ".json":
+		records, err := parseBillingJSONL(file)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", path, err)
+		}
+		return records, nil
+	default:
+		return nil, fmt.Errorf("unsupported billing export format: %s", path)
+	}
+}
+
+type exportLabel struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type exportRow struct {
+	BillingAccountID string `json:"billing_account_id"`
+	Project          struct {
+		ID     string        `json:"id"`
+		Name   string        `json:"name"`
+		Labels []exportLabel `json:"labels"`
+	} `json:"project"`
+	Service struct {
+		Description string `json:"description"`
+	} `json:"service"`
+	SKU struct {
+		Description string `json:"description"`
+	} `json:"sku"`
+	Location struct {
+		Region string `json:"region"`
+	} `json:"location"`
+	Labels         []exportLabel `json:"labels"`
+	Credits        []Credit      `json:"credits"`
+	Cost           float64       `json:"cost"`
+	Currency       string        `json:"currency"`
+	UsageStartTime string        `json:"usage_start_time"`
+	PartitionTime  string        `json:"_PARTITIONTIME"`
+	Invoice        struct {
+		Month string `json:"month"`
+	} `json:"invoice"`
+}
+
+func parseBillingJSONL(r io.Reader) ([]BillingRecord, error) {
+	var records []BillingRecord
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var row exportRow
+		if err := json.Unmarshal([]byte(text), &row); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		record := BillingRecord{
+			BillingAccountID: row.BillingAccountID,
+			ProjectID:        row.Project.ID,
+			ProjectName:      row.Project.Name,
+			ProjectLabels:    labelsToMap(row.Project.Labels),
+			Service:          row.Service.Description,
+			SKU:              row.SKU.Description,
+			Region:           row.Location.Region,
+			Cost:             row.Cost,
+			Currency:         row.Currency,
+			InvoiceMonth:     row.Invoice.Month,
+			Labels:           labelsToMap(row.Labels),
+			Credits:          row.Credits,
+		}
+		var err error
+		if record.UsageStartTime, err = parseExportTime(row.UsageStartTime); err != nil {
+			return nil, fmt.Errorf("line %d: usage_start_time: %v", line, err)
+		}
+		if record.PartitionTime, err = parseExportTime(row.PartitionTime); err != nil {
+			return nil, fmt.Errorf("line %d: _PARTITIONTIME: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// parseBillingCSV 读取扁平化的导出表，嵌套字段使用 "project.id" 形式的列名，labels 与 credits 为 JSON 数组
+func parseBillingCSV(r io.Reader) ([]BillingRecord, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading header: %v", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, ok := columns["project.id"]; !ok {
+		return nil, fmt.Errorf("missing column project.id")
+	}
+	if _, ok := columns["cost"]; !ok {
+		return nil, fmt.Errorf("missing column cost")
+	}
+
+	get := func(row []string, names ...string) string {
+		for _, name := range names {
+			if idx, ok := columns[name]; ok && idx < len(row) {
+				return row[idx]
+			}
+		}
+		return ""
+	}
+
+	var records []BillingRecord
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		record := BillingRecord{
+			BillingAccountID: get(row, "billing_account_id"),
+			ProjectID:        get(row, "project.id"),
+			ProjectName:      get(row, "project.name"),
+			Service:          get(row, "service.description", "service"),
+			SKU:              get(row, "sku.description", "sku"),
+			Region:           get(row, "location.region", "region"),
+			Currency:         get(row, "currency"),
+			InvoiceMonth:     get(row, "invoice.month"),
+		}
+		if record.Cost, err = strconv.ParseFloat(get(row, "cost"), 64); err != nil {
+			return nil, fmt.Errorf("line %d: cost: %v", line, err)
+		}
+		if record.UsageStartTime, err = parseExportTime(get(row, "usage_start_time")); err != nil {
+			return nil, fmt.Errorf("line %d: usage_start_time: %v", line, err)
+		}
+		if record.PartitionTime, err = parseExportTime(get(row, "_PARTITIONTIME")); err != nil {
+			return nil, fmt.Errorf("line %d: _PARTITIONTIME: %v", line, err)
+		}
+		if record.ProjectLabels, err = decodeLabels(get(row, "project.labels")); err != nil {
+			return nil, fmt.Errorf("line %d: project.labels: %v", line, err)
+		}
+		if record.Labels, err = decodeLabels(get(row, "labels")); err != nil {
+			return nil, fmt.Errorf("line %d: labels: %v", line, err)
+		}
+		if credits := get(row, "credits"); credits != "" {
+			if err := json.Unmarshal([]byte(credits), &record.Credits); err != nil {
+				return nil, fmt.Errorf("line %d: credits: %v", line, err)
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// decodeLabels 解析 [{"key":...,"value":...}] 格式的标签 JSON
+func decodeLabels(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var labels []exportLabel
+	if err := json.Unmarshal([]byte(value), &labels); err != nil {
+		return nil, err
+	}
+	return labelsToMap(labels), nil
+}
+
+func labelsToMap(labels []exportLabel) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(labels))
+	for _, l := range labels {
+		m[l.Key] = l.Value
+	}
+	return m
+}
+
+// parseExportTime 兼容 BigQuery 导出的 "2006-01-02 15:04:05 UTC"、RFC3339 以及纯日期
+func parseExportTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	layouts := []string{
+		time.RFC3339Nano,
+		"2006-01-02 15:04:05.999999 MST",
+		"2006-01-02 15:04:05 MST",
+		"2006-01-02 15:04:05.999999",
+		"2006-01-02 15:04:05",
+		"2006-01-02",
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", value)
+}
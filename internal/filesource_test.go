@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadBillingExport(t *testing.T) {
+	for _, path := range []string{"testdata/billing_export.csv", "testdata/billing_export.jsonl"} {
+		t.Run(path, func(t *testing.T) {
+			records, err := LoadBillingExport(path)
+			require.NoError(t, err)
+			require.Len(t, records, 3)
+
+			first := records[0]
+			assert.Equal(t, "prod-app", first.ProjectID)
+			assert.Equal(t, "Prod App", first.ProjectName)
+			assert.Equal(t, map[string]string{"env": "prod"}, first.ProjectLabels)
+			assert.Equal(t, "Compute Engine", first.Service)
+			assert.Equal(t, "N2 Instance Core", first.SKU)
+			assert.Equal(t, 120.5, first.Cost)
+			assert.Equal(t, map[string]string{"env": "prod"}, first.Labels)
+			assert.Equal(t, []Credit{{Name: "SUD", Amount: -10.5, Type: "SUSTAINED_USAGE_DISCOUNT"}}, first.Credits)
+			assert.Equal(t, time.Date(2024, 3, 4, 8, 0, 0, 0, time.UTC), first.UsageStartTime)
+			assert.Equal(t, time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), first.PartitionTime)
+
+			// 缺少 _PARTITIONTIME 时按用量开始日期归入分区
+			assert.True(t, records[2].PartitionTime.IsZero())
+			assert.Equal(t, time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC), records[2].partitionTime())
+		})
+	}
+}
+
+func TestLoadBillingExportUnsupported(t *testing.T) {
+	_, err := LoadBillingExport("testdata/billing_export.parquet")
+	assert.Error(t, err)
+}
+
+func TestCompareRecords(t *testing.T) {
+	records, err := LoadBillingExport("testdata/billing_export.csv")
+	require.NoError(t, err)
+
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+	w := Window{PrevStart: day(4), PrevEnd: day(5), CurStart: day(5), CurEnd: day(6)}
+
+	prodApp := ProjectCostComparison{
+		ProjectID: "prod-app", ProjectName: "Prod App", Currency: "USD",
+		ProjectLabels: map[string]string{"env": "prod"},
+		PreviousCost:  120.5, CurrentCost: 30, Delta: -90.5, DeltaPercent: -90.5 / 120.5 * 100,
+		PreviousCredits: -10.5,
+		Credits:         []CreditTotal{{Type: "SUSTAINED_USAGE_DISCOUNT", Previous: -10.5}},
+	}
+	sandbox := ProjectCostComparison{
+		ProjectID: "sandbox", ProjectName: "Sandbox", Currency: "USD",
+		CurrentCost: 5, Delta: 5,
+	}
+
+	rows := compareRecords(records, Dimension{}, costModeGross, w)
+	assert.Equal(t, []ProjectCostComparison{sandbox, prodApp}, rows)
+
+	// net 口径下上期费用扣除了持续使用折扣
+	prodApp.PreviousCost, prodApp.Net = 110, true
+	prodApp.computeDelta()
+	sandbox.Net = true
+	rows = compareRecords(records, Dimension{}, costModeNet, w)
+	assert.Equal(t, []ProjectCostComparison{sandbox, prodApp}, rows)
+	assert.Equal(t, rows, WithCostMode(compareRecords(records, Dimension{}, costModeGross, w), costModeNet))
+}
+
+func TestFileSourceNetCost(t *testing.T) {
+	source, err := NewFileSource(Dimension{}, "testdata/billing_export.csv")
+	require.NoError(t, err)
+
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+	costs, err := source.WithCostMode(costModeNet).DailyCosts(context.Background(), day(4), day(5))
+	require.NoError(t, err)
+	require.Len(t, costs, 1)
+	assert.Equal(t, 110.0, costs[0].Cost)
+
+	w := Window{PrevStart: day(4), PrevEnd: day(5), CurStart: day(5), CurEnd: day(6)}
+	breakdown, err := source.WithCostMode(costModeNet).CostBreakdown(context.Background(), w, []string{"prod-app"})
+	require.NoError(t, err)
+	assert.Equal(t, 110.0, breakdown[0].PreviousCost)
+}
+
+func TestFileSourceDailyCosts(t *testing.T) {
+	source, err := NewFileSource(Dimension{}, "testdata/billing_export.jsonl")
+	require.NoError(t, err)
+
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+	costs, err := source.DailyCosts(context.Background(), day(4), day(6))
+	require.NoError(t, err)
+	assert.Equal(t, []ProjectDailyCost{
+		{ProjectID: "prod-app", ProjectName: "Prod App", Day: day(4), Cost: 120.5, Currency: "USD", ProjectLabels: map[string]string{"env": "prod"}},
+		{ProjectID: "prod-app", ProjectName: "Prod App", Day: day(5), Cost: 30, Currency: "USD", ProjectLabels: map[string]string{"env": "prod"}},
+		{ProjectID: "sandbox", ProjectName: "Sandbox", Day: day(5), Cost: 5, Currency: "USD"},
+	}, costs)
+
+	costs, err = source.DailyCosts(context.Background(), day(5), day(6))
+	require.NoError(t, err)
+	assert.Len(t, costs, 2)
+}
+
+func TestFileSourceClock(t *testing.T) {
+	source, err := NewFileSource(Dimension{}, "testdata/billing_export.csv")
+	require.NoError(t, err)
+	calendar, err := NewCalendar("Asia/Shanghai", "")
+	require.NoError(t, err)
+
+	// 上海 3/6 00:30，昨天为 3/5，前天为 3/4
+	clock := FixedClock(time.Date(2024, 3, 5, 16, 30, 0, 0, time.UTC))
+	rows, err := source.WithCalendar(calendar.WithClock(clock)).DailyUsage(context.Background())
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "prod-app", rows[1].ProjectID)
+	assert.Equal(t, 120.5, rows[1].PreviousCost)
+	assert.Equal(t, 30.0, rows[1].CurrentCost)
+}
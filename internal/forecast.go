@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	forecastLinear  = "linear"
+	forecastWeekday = "weekday"
+)
+
+// ProjectForecast 项目本月费用的月末预测
+type ProjectForecast struct {
+	ProjectID     string
+	ProjectName   string
+	Currency      string
+	LastMonthCost float64
+	MonthToDate   float64
+	Forecast      float64
+	// ForecastDelta 预测值与上月的差，ForecastDeltaPercent 上月无费用时为 0
+	ForecastDelta        float64
+	ForecastDeltaPercent float64
+	// Budget 为 0 表示未配置预算
+	Budget        float64
+	BudgetPercent float64
+}
+
+// OverBudget 预测值是否超出预算
+func (f ProjectForecast) OverBudget() bool {
+	return f.Budget > 0 && f.Forecast > f.Budget
+}
+
+// ForecastCase 根据本月已完成天数的费用预测月末总费用
+type ForecastCase struct {
+	source      BillingSource
+	method      string
+	historyDays int
+	budgets     map[string]float64
+	calendar    *Calendar
+}
+
+// NewForecastCase calendar 为 nil 时按配置的报表时区创建
+func NewForecastCase(source BillingSource, cfg *config.Config, calendar *Calendar) (*ForecastCase, error) {
+	method := strings.ToLower(cfg.Forecast.Method)
+	if method == "" {
+		method = forecastLinear
+	}
+	if method != forecastLinear && method != forecastWeekday {
+		return nil, fmt.Errorf("invalid forecast method %q, expected linear/weekday", cfg.Forecast.Method)
+	}
+	historyDays := cfg.Forecast.HistoryDays
+	if historyDays <= 0 {
+		historyDays = 28
+	}
+	if calendar == nil {
+		var err error
+		if calendar, err = NewCalendar(cfg.Report.Timezone, cfg.Report.WeekStart); err != nil {
+			return nil, err
+		}
+	}
+	budgets := make(map[string]float64)
+	for _, b := range cfg.Budgets {
+		if b.Project != "" {
+			budgets[b.Project] += b.Amount
+		}
+	}
+	return &ForecastCase{source: source, method: method, historyDays: historyDays, budgets: budgets, calendar: calendar}, nil
+}
+
+// MonthForecast 预测本月每个项目的月末费用，按预测增长降序排列
+func (f *ForecastCase) MonthForecast(ctx context.Context) ([]ProjectForecast, error) {
+	now := f.calendar.Now()
+	month := f.calendar.MonthWindow(now)
+	today := f.calendar.Today(now)
+	start := month.PrevStart
+	if historyStart := today.AddDate(0, 0, -f.historyDays); historyStart.Before(start) {
+		start = historyStart
+	}
+	costs, err := f.source.DailyCosts(ctx, start, today)
+	if err != nil {
+		return nil, err
+	}
+	return forecastMonth(costs, month, today, f.method, f.historyDays, f.budgets), nil
+}
+
+// forecastMonth today 之前的天为已完成的天，month.CurStart 至 today 的费用为本月已用量
+func forecastMonth(costs []ProjectDailyCost, month Window, today time.Time, method string, historyDays int, budgets map[string]float64) []ProjectForecast {
+	type history struct {
+		forecast ProjectForecast
+		byDay    map[time.Time]float64
+	}
+	projects := make(map[string]*history)
+	var order []string
+	for _, c := range costs {
+		h, ok := projects[c.ProjectID]
+		if !ok {
+			h = &history{forecast: ProjectForecast{ProjectID: c.ProjectID}, byDay: make(map[time.Time]float64)}
+			projects[c.ProjectID] = h
+			order = append(order, c.ProjectID)
+		}
+		if h.forecast.ProjectName == "" {
+			h.forecast.ProjectName = c.ProjectName
+		}
+		if h.forecast.Currency == "" {
+			h.forecast.Currency = c.Currency
+		}
+		h.byDay[c.Day] += c.Cost
+		if prev, cur := month.Contains(c.Day); prev {
+			h.forecast.LastMonthCost += c.Cost
+		} else if cur && c.Day.Before(today) {
+			h.forecast.MonthToDate += c.Cost
+		}
+	}
+
+	var res []ProjectForecast
+	for _, projectID := range order {
+		h := projects[projectID]
+		weights := [7]float64{1, 1, 1, 1, 1, 1, 1}
+		if method == forecastWeekday {
+			weights = weekdayWeights(h.byDay, today, historyDays)
+		}
+
+		var elapsed, remaining float64
+		for d := month.CurStart; d.Before(month.CurEnd); d = d.AddDate(0, 0, 1) {
+			if d.Before(today) {
+				elapsed += weights[d.Weekday()]
+			} else {
+				remaining += weights[d.Weekday()]
+			}
+		}
+
+		f := h.forecast
+		f.Forecast = f.MonthToDate
+		if elapsed > 0 {
+			f.Forecast += f.MonthToDate / elapsed * remaining
+		}
+		f.ForecastDelta = f.Forecast - f.LastMonthCost
+		if f.LastMonthCost != 0 {
+			f.ForecastDeltaPercent = f.ForecastDelta / f.LastMonthCost * 100
+		}
+		if budget := budgets[projectID]; budget > 0 {
+			f.Budget = budget
+			f.BudgetPercent = f.Forecast / budget * 100
+		}
+		res = append(res, f)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].ForecastDelta > res[j].ForecastDelta
+	})
+	return res
+}
+
+// weekdayWeights 各星期几的日均费用相对整体日均费用的比例，缺失的日期视为费用 0
+func weekdayWeights(byDay map[time.Time]float64, today time.Time, historyDays int) [7]float64 {
+	var sums, counts [7]float64
+	var total float64
+	for i := 1; i <= historyDays; i++ {
+		d := today.AddDate(0, 0, -i)
+		sums[d.Weekday()] += byDay[d]
+		counts[d.Weekday()]++
+		total += byDay[d]
+	}
+
+	weights := [7]float64{1, 1, 1, 1, 1, 1, 1}
+	if total <= 0 {
+		return weights
+	}
+	avg := total / float64(historyDays)
+	for wd := range weights {
+		if counts[wd] > 0 {
+			weights[wd] = sums[wd] / counts[wd] / avg
+		}
+	}
+	return weights
+}
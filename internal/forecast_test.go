@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForecastMonth(t *testing.T) {
+	day := func(m time.Month, d int) time.Time { return time.Date(2024, m, d, 0, 0, 0, 0, time.UTC) }
+	month := Window{PrevStart: day(2, 1), PrevEnd: day(3, 1), CurStart: day(3, 1), CurEnd: day(4, 1)}
+	today := day(3, 11)
+
+	// 工作日每天 10，周末无费用
+	var costs []ProjectDailyCost
+	for d := day(2, 1); d.Before(today); d = d.AddDate(0, 0, 1) {
+		if d.Weekday() != time.Saturday && d.Weekday() != time.Sunday {
+			costs = append(costs, ProjectDailyCost{ProjectID: "office", Day: d, Cost: 10, Currency: "USD"})
+		}
+	}
+	// 今天的费用尚不完整，不计入本月已用量
+	costs = append(costs, ProjectDailyCost{ProjectID: "office", Day: today, Cost: 999})
+	budgets := map[string]float64{"office": 200}
+
+	linear := forecastMonth(costs, month, today, forecastLinear, 28, budgets)
+	require.Len(t, linear, 1)
+	assert.Equal(t, 210.0, linear[0].LastMonthCost)
+	assert.Equal(t, 60.0, linear[0].MonthToDate)
+	assert.InDelta(t, 186, linear[0].Forecast, 0.001)
+	assert.InDelta(t, -24, linear[0].ForecastDelta, 0.001)
+	assert.InDelta(t, 93, linear[0].BudgetPercent, 0.001)
+	assert.False(t, linear[0].OverBudget())
+
+	weekday := forecastMonth(costs, month, today, forecastWeekday, 28, budgets)
+	require.Len(t, weekday, 1)
+	assert.InDelta(t, 210, weekday[0].Forecast, 0.001)
+	assert.InDelta(t, 0, weekday[0].ForecastDelta, 0.001)
+	assert.InDelta(t, 105, weekday[0].BudgetPercent, 0.001)
+	assert.True(t, weekday[0].OverBudget())
+}
+
+func TestNewForecastCase(t *testing.T) {
+	cfg := &config.Config{Budgets: []config.Budget{{Project: "a", Amount: 100}, {Amount: 50}}}
+	forecastCase, err := NewForecastCase(&MemorySource{}, cfg, nil)
+	require.NoError(t, err)
+	assert.Equal(t, forecastLinear, forecastCase.method)
+	assert.Equal(t, map[string]float64{"a": 100}, forecastCase.budgets)
+
+	cfg.Forecast.Method = "arima"
+	_, err = NewForecastCase(&MemorySource{}, cfg, nil)
+	assert.Error(t, err)
+}
+
+func TestFormatForecastToString(t *testing.T) {
+	rows := []ProjectForecast{
+		{ProjectID: "growing", Forecast: 300, LastMonthCost: 100, ForecastDelta: 200, ForecastDeltaPercent: 200},
+		{ProjectID: "steady", Forecast: 100, LastMonthCost: 100},
+		{ProjectID: "over-budget", Forecast: 90, LastMonthCost: 100, ForecastDelta: -10, ForecastDeltaPercent: -10, Budget: 50, BudgetPercent: 180},
+	}
+	res := formatForecastToString(rows, 1)
+	assert.Contains(t, res, "growing")
+	assert.NotContains(t, res, "steady")
+	assert.Contains(t, res, "over-budget: \n\t上月用量: 100.00\t本月已用: 0.00\t预测本月: 90.00\t预测差: -10.00 (-10.0%)\t预算: 50.00 (180.0%)\n")
+}
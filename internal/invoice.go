@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// monthBasisUsage 按 _PARTITIONTIME 所在月统计(默认)
+	monthBasisUsage = "usage"
+	// monthBasisInvoice 按 invoice.month 统计，与 GCP 发票一致
+	monthBasisInvoice = "invoice"
+
+	// invoiceGraceDays 发票月结束后仍可能导出计入该月发票的天数
+	invoiceGraceDays = 5
+
+	// invoiceTimezone 发票月按美国太平洋时间划分
+	invoiceTimezone = "America/Los_Angeles"
+)
+
+// invoiceMonthStart 发票月 YYYYMM 在太平洋时间的开始时刻
+func invoiceMonthStart(month string) (time.Time, error) {
+	location, err := time.LoadLocation(invoiceTimezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error loading invoice timezone: %v", err)
+	}
+	return time.ParseInLocation("200601", month, location)
+}
+
+// ParseMonthBasis 支持 usage(默认) 与 invoice
+func ParseMonthBasis(value string) (string, error) {
+	switch basis := strings.ToLower(strings.TrimSpace(value)); basis {
+	case "", monthBasisUsage:
+		return monthBasisUsage, nil
+	case monthBasisInvoice:
+		return monthBasisInvoice, nil
+	default:
+		return "", fmt.Errorf("invalid month basis %q, expected usage/invoice", value)
+	}
+}
+
+// invoiceMonth 与 invoice.month 格式一致，如 202403
+func invoiceMonth(t time.Time) string {
+	return t.Format("200601")
+}
+
+// MonthReconciliation 同一项目本月按分区统计与按发票月统计的费用对比
+type MonthReconciliation struct {
+	Group       string
+	ProjectID   string
+	ProjectName string
+	Currency    string
+	UsageCost   float64
+	InvoiceCost float64
+	// Difference 发票金额减分区金额，Adjustments 为发票中延迟到账的往月费用
+	Difference  float64
+	Adjustments float64
+}
+
+// Reconcile 对比本月按分区(usage)和按发票月(invoice)统计的费用，按差额绝对值降序排列
+func Reconcile(usage, invoice []ProjectCostComparison) []MonthReconciliation {
+	type key struct{ group, projectID string }
+	byProject := make(map[key]*MonthReconciliation)
+	var order []key
+	get := func(row ProjectCostComparison) *MonthReconciliation {
+		k := key{row.Group, row.ProjectID}
+		r, ok := byProject[k]
+		if !ok {
+			r = &MonthReconciliation{Group: row.Group, ProjectID: row.ProjectID}
+			byProject[k] = r
+			order = append(order, k)
+		}
+		if r.ProjectName == "" {
+			r.ProjectName = row.ProjectName
+		}
+		if r.Currency == "" {
+			r.Currency = row.Currency
+		}
+		return r
+	}
+	for _, row := range usage {
+		get(row).UsageCost += row.CurrentCost
+	}
+	for _, row := range invoice {
+		r := get(row)
+		r.InvoiceCost += row.CurrentCost
+		r.Adjustments += row.CurrentAdjustments
+	}
+
+	res := make([]MonthReconciliation, 0, len(order))
+	for _, k := range order {
+		r := byProject[k]
+		r.Difference = r.InvoiceCost - r.UsageCost
+		res = append(res, *r)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return math.Abs(res[i].Difference) > math.Abs(res[j].Difference)
+	})
+	return res
+}
@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"cloud.google.com/go/bigquery"
+	"clzrt.io/billingUsage/internal/config"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMonthBasis(t *testing.T) {
+	for value, want := range map[string]string{"": monthBasisUsage, "usage": monthBasisUsage, "Invoice": monthBasisInvoice} {
+		basis, err := ParseMonthBasis(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, want, basis, value)
+	}
+	_, err := ParseMonthBasis("calendar")
+	assert.Error(t, err)
+}
+
+func TestCompareInvoiceMonths(t *testing.T) {
+	records, err := LoadBillingExport("testdata/invoice_export.csv")
+	require.NoError(t, err)
+
+	month := func(m time.Month) time.Time { return time.Date(2024, m, 1, 0, 0, 0, 0, time.UTC) }
+	w := Window{PrevStart: month(2), PrevEnd: month(3), CurStart: month(3), CurEnd: month(4)}
+
+	// 按分区统计时 4 月 2 日导出的 3 月用量不计入本月，2 月的延迟用量计入本月
+	usage := compareRecords(records, Dimension{}, costModeGross, w)
+	require.Len(t, usage, 3)
+	assert.Equal(t, "prod-app", usage[0].ProjectID)
+	assert.Equal(t, 215.0, usage[0].CurrentCost)
+
+	invoice := compareInvoiceMonths(records, Dimension{}, costModeGross, w)
+	require.Len(t, invoice, 3)
+	prod := invoice[0]
+	assert.Equal(t, "prod-app", prod.ProjectID)
+	assert.Equal(t, 100.0, prod.PreviousCost)
+	assert.Equal(t, 225.0, prod.CurrentCost)
+	assert.Equal(t, 15.0, prod.CurrentAdjustments)
+	assert.Equal(t, -20.0, prod.CurrentCredits)
+	// 发票月按太平洋时间划分，UTC 3 月 1 日凌晨的用量仍属于 2 月，是延迟调整
+	late := invoice[1]
+	assert.Equal(t, "late-app", late.ProjectID)
+	assert.Equal(t, 8.0, late.CurrentAdjustments)
+	// 缺少 invoice.month 的行按分区所在月归入发票
+	assert.Equal(t, 5.0, invoice[2].CurrentCost)
+
+	// net 口径下调整费用同样扣除抵扣
+	net := compareInvoiceMonths(records, Dimension{}, costModeNet, w)
+	assert.Equal(t, 205.0, net[0].CurrentCost)
+	assert.Equal(t, "late-app", net[1].ProjectID)
+	assert.Equal(t, 6.0, net[1].CurrentCost)
+	assert.Equal(t, 6.0, net[1].CurrentAdjustments)
+	assert.Equal(t, 8.0, WithCostMode(net, costModeGross)[1].CurrentAdjustments)
+
+	reconciliation := Reconcile(usage, invoice)
+	require.Len(t, reconciliation, 3)
+	assert.Equal(t, MonthReconciliation{
+		ProjectID: "prod-app", ProjectName: "Prod App", Currency: "USD",
+		UsageCost: 215, InvoiceCost: 225, Difference: 10, Adjustments: 15,
+	}, reconciliation[0])
+	assert.Equal(t, 0.0, reconciliation[2].Difference)
+}
+
+func TestInvoiceMonthQuery(t *testing.T) {
+	builder, err := NewQueryBuilder("billing.export.costs")
+	require.NoError(t, err)
+
+	month := func(m time.Month) time.Time { return time.Date(2024, m, 1, 0, 0, 0, 0, time.UTC) }
+	w := Window{PrevStart: month(2), PrevEnd: month(3), CurStart: month(3), CurEnd: month(4)}
+	q := builder.InvoiceMonthQuery(w)
+	assert.Contains(t, q.SQL, "invoice.month = @prev_month AS in_previous, invoice.month = @cur_month AS in_current ")
+	assert.Contains(t, q.SQL, "WHERE _PARTITIONTIME >= @prev_start AND _PARTITIONTIME < @partition_end AND invoice.month IN (@prev_month, @cur_month) ")
+	assert.Contains(t, q.SQL, "usage_start_time < PARSE_TIMESTAMP('%Y%m', invoice.month, 'America/Los_Angeles') AS is_adjustment, ")
+	assert.Contains(t, q.SQL, "previous_adjustments_gross AS previous_adjustments, current_adjustments_gross AS current_adjustments, ")
+
+	net := builder.WithCostMode(costModeNet).InvoiceMonthQuery(w)
+	assert.Contains(t, net.SQL, "previous_adjustments_gross + previous_adjustment_credits AS previous_adjustments, ")
+	assert.Equal(t, []bigquery.QueryParameter{
+		{Name: "prev_month", Value: "202402"},
+		{Name: "cur_month", Value: "202403"},
+		{Name: "prev_start", Value: month(2)},
+		{Name: "partition_end", Value: time.Date(2024, 4, 6, 0, 0, 0, 0, time.UTC)},
+	}, q.Params)
+
+	// 按分区统计的查询不包含调整列
+	assert.NotContains(t, builder.MonthQuery(w).SQL, "adjustments")
+}
+
+func TestMonthCheckInvoiceBasis(t *testing.T) {
+	source := NewMemorySource(nil, nil, []ProjectCostComparison{comparison("steady", 100, 100)})
+	source.InvoiceMonth = []ProjectCostComparison{comparison("late", 100, 200)}
+
+	cfg := &config.Config{}
+	cfg.Report.MonthBasis = "invoice"
+	checkCase, err := NewUsageCheckCase(source, cfg, nil)
+	require.NoError(t, err)
+
+	result, err := checkCase.MonthCheck(context.Background())
+	require.NoError(t, err)
+	require.Len(t, result.Anomalies, 1)
+	assert.Equal(t, "late", result.Anomalies[0].ProjectID)
+
+	reconciliation, err := checkCase.MonthReconciliation(context.Background(), source.InvoiceMonth)
+	require.NoError(t, err)
+	assert.Len(t, reconciliation, 2)
+}
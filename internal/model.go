@@ -0,0 +1,75 @@
+package internal
+
+import "time"
+
+// ProjectCostComparison 单个项目在相邻两个周期的费用对比
+// 按项目以外的维度分组时 Group 为分组键，分组汇总行的 ProjectID 为空
+// Net 为 false 时 PreviousCost/CurrentCost 为 cost 列合计(gross)，为 true 时已加上抵扣(net)
+type ProjectCostComparison struct {
+	Group        string
+	ProjectID    string
+	ProjectName  string
+	PreviousCost float64
+	CurrentCost  float64
+	Delta        float64
+	// DeltaPercent 相对上期的变化百分比，上期无费用时为 0
+	DeltaPercent float64
+	Currency     string
+	// ProjectLabels 项目标签，用于按标签匹配规则
+	ProjectLabels map[string]string
+	// PreviousCredits/CurrentCredits 两期抵扣合计(负数)，Credits 为按类型拆分的抵扣
+	PreviousCredits float64
+	CurrentCredits  float64
+	Credits         []CreditTotal
+	Net             bool
+	// PreviousAdjustments/CurrentAdjustments 按发票月统计时，发票中用量早于发票月的延迟调整费用，与费用一样按 Net 计算
+	// PreviousAdjustmentCredits/CurrentAdjustmentCredits 为其中的抵扣，用于换算费用口径
+	PreviousAdjustments       float64
+	CurrentAdjustments        float64
+	PreviousAdjustmentCredits float64
+	CurrentAdjustmentCredits  float64
+}
+
+// Key 分组键，按项目分组时为项目id
+func (c ProjectCostComparison) Key() string {
+	if c.Group != "" {
+		return c.Group
+	}
+	return c.ProjectID
+}
+
+// computeDelta 根据两期费用计算差值与变化率
+func (c *ProjectCostComparison) computeDelta() {
+	c.Delta = c.CurrentCost - c.PreviousCost
+	c.DeltaPercent = 0
+	if c.PreviousCost != 0 {
+		c.DeltaPercent = c.Delta / c.PreviousCost * 100
+	}
+}
+
+// ProjectDailyCost 单个项目某一天的费用
+type ProjectDailyCost struct {
+	ProjectID   string
+	ProjectName string
+	Day         time.Time
+	Cost        float64
+	Currency    string
+	// ProjectLabels 项目标签
+	ProjectLabels map[string]string
+}
+
+// CostBreakdown 项目下某个服务(及 SKU)在相邻两个周期的费用对比，SKU 为空表示整个服务
+type CostBreakdown struct {
+	ProjectID    string
+	Service      string
+	SKU          string
+	PreviousCost float64
+	CurrentCost  float64
+	Delta        float64
+}
+
+// ProjectDrillDown 异常项目中费用变化最大的服务和 SKU
+type ProjectDrillDown struct {
+	Services []CostBreakdown
+	SKUs     []CostBreakdown
+}
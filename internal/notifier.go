@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Alert 一条通知，各渠道按自己的格式展示其中的内容
+type Alert struct {
+	Title string
+	// Message 附加在明细前的文字
+	Message   string
+	Anomalies []Anomaly
+	Baseline  []BaselineAnomaly
+	// Forecasts 需按预测增长降序排列，只展示前 ForecastTopN 个和超出预算的项目
+	Forecasts    []ProjectForecast
+	ForecastTopN int
+	Budgets      []BudgetAlert
+	// ReportURL 对应 Excel 报表的链接，没有报表时为空
+	ReportURL string
+}
+
+// Severity 通知的严重程度
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+// Severity 预算用完为 critical，有异常、偏离基线、预算报警或预测超出预算为 warning，其他为 info
+func (a Alert) Severity() Severity {
+	for _, row := range a.Budgets {
+		if row.Percent >= 100 {
+			return SeverityCritical
+		}
+	}
+	if len(a.Anomalies) > 0 || len(a.Baseline) > 0 || len(a.Budgets) > 0 {
+		return SeverityWarning
+	}
+	for _, row := range a.Forecasts {
+		if row.OverBudget() {
+			return SeverityWarning
+		}
+	}
+	return SeverityInfo
+}
+
+// Text 通知内容的纯文本格式
+func (a Alert) Text() string {
+	var result strings.Builder
+	if a.Message != "" {
+		result.WriteString(a.Message + "\n")
+	}
+	result.WriteString(formatRowsToString(a.Anomalies))
+	result.WriteString(formatBaselineToString(a.Baseline))
+	result.WriteString(formatForecastToString(a.Forecasts, a.ForecastTopN))
+	result.WriteString(formatBudgetToString(a.Budgets))
+	return result.String()
+}
+
+// Notifier 通知渠道
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// Notifiers 将通知发送到所有渠道，一个渠道失败不影响其他渠道
+type Notifiers []Notifier
+
+func (n Notifiers) Notify(ctx context.Context, alert Alert) error {
+	_, err := n.Send(ctx, alert)
+	return err
+}
+
+// Send 将通知发送到所有渠道，返回发送成功的渠道数
+func (n Notifiers) Send(ctx context.Context, alert Alert) (int, error) {
+	var errs []error
+	delivered := 0
+	for _, notifier := range n {
+		if err := notifier.Notify(ctx, alert); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delivered++
+	}
+	return delivered, errors.Join(errs...)
+}
+
+// namedNotifier 错误信息中带上渠道名称
+type namedNotifier struct {
+	name     string
+	notifier Notifier
+}
+
+func (n namedNotifier) Notify(ctx context.Context, alert Alert) error {
+	if err := n.notifier.Notify(ctx, alert); err != nil {
+		return fmt.Errorf("%s: %v", n.name, err)
+	}
+	return nil
+}
+
+// NotifierFactory 根据配置创建通知渠道，dryRunDir 不为空时渠道不发送，只将请求写入该目录
+type NotifierFactory func(cfg config.Notifier, dryRunDir string) (Notifier, error)
+
+// NotifierRegistry 按 type 创建通知渠道，新增渠道只需注册对应的 NotifierFactory
+type NotifierRegistry struct {
+	factories map[string]NotifierFactory
+}
+
+// NewNotifierRegistry 返回注册了内置渠道的 registry
+func NewNotifierRegistry() *NotifierRegistry {
+	r := &NotifierRegistry{factories: make(map[string]NotifierFactory)}
+	r.Register("dingtalk", newDingTalkNotifier)
+	r.Register("wecom", newWeComNotifier)
+	r.Register("feishu", newFeiShuNotifier)
+	return r
+}
+
+// Register 注册渠道类型，同名时覆盖
+func (r *NotifierRegistry) Register(channelType string, factory NotifierFactory) {
+	r.factories[channelType] = factory
+}
+
+// Types 已注册的渠道类型
+func (r *NotifierRegistry) Types() []string {
+	types := make([]string, 0, len(r.factories))
+	for channelType := range r.factories {
+		types = append(types, channelType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// New 创建配置中的所有渠道，返回所有配置错误
+func (r *NotifierRegistry) New(channels []config.Notifier, dryRunDir string) (Notifiers, error) {
+	var notifiers Notifiers
+	var errs []error
+	for i, channel := range channels {
+		name := channel.Name
+		if name == "" {
+			name = channel.Type
+		}
+		factory, ok := r.factories[channel.Type]
+		if !ok {
+			errs = append(errs, fmt.Errorf("notifier %d: unknown type %q, expected one of %v", i, channel.Type, r.Types()))
+			continue
+		}
+		notifier, err := factory(channel, dryRunDir)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("notifier %s: %v", name, err))
+			continue
+		}
+		notifiers = append(notifiers, namedNotifier{name: name, notifier: notifier})
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return notifiers, nil
+}
+
+// FromConfig 创建 cfg 中配置的渠道，webhook.url 不为空时作为第一个钉钉渠道
+func (r *NotifierRegistry) FromConfig(cfg *config.Config, dryRunDir string) (Notifiers, error) {
+	var errs []error
+	var webHook Notifier
+	if cfg.Webhook.URL != "" {
+		var err error
+		webHook, err = newDingTalkNotifier(config.Notifier{URL: cfg.Webhook.URL, KeyWord: cfg.Webhook.KeyWord}, dryRunDir)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("webhook: %v", err))
+		}
+	}
+	notifiers, err := r.New(cfg.Notifiers, dryRunDir)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if webHook == nil {
+		return notifiers, nil
+	}
+	return append(Notifiers{namedNotifier{name: "webhook", notifier: webHook}}, notifiers...), nil
+}
@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingNotifier 记录收到的通知
+type recordingNotifier struct {
+	alerts []Alert
+	err    error
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, alert Alert) error {
+	n.alerts = append(n.alerts, alert)
+	return n.err
+}
+
+func TestNotifierRegistry(t *testing.T) {
+	ctx := context.Background()
+	var messages []map[string]interface{}
+	robot := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&message))
+		messages = append(messages, message)
+	}))
+	defer robot.Close()
+
+	// 新增渠道只需注册
+	recorder := &recordingNotifier{}
+	failing := &recordingNotifier{err: errors.New("unavailable")}
+	registry := NewNotifierRegistry()
+	registry.Register("recorder", func(cfg config.Notifier, dryRunDir string) (Notifier, error) {
+		if cfg.Name == "failing" {
+			return failing, nil
+		}
+		return recorder, nil
+	})
+	assert.Equal(t, []string{"dingtalk", "feishu", "recorder", "wecom"}, registry.Types())
+
+	cfg := &config.Config{Notifiers: []config.Notifier{{Type: "recorder"}, {Type: "recorder", Name: "failing"}}}
+	cfg.Webhook.URL = robot.URL
+	cfg.Webhook.KeyWord = "billing"
+	notifiers, err := registry.FromConfig(cfg, "")
+	require.NoError(t, err)
+	require.Len(t, notifiers, 3)
+
+	// 一个渠道失败时其他渠道仍然收到通知
+	alert := Alert{Title: "daily Warning", Anomalies: []Anomaly{{ProjectCostComparison: comparison("web", 100, 150)}}}
+	err = notifiers.Notify(ctx, alert)
+	require.Error(t, err)
+	assert.Equal(t, "failing: unavailable", err.Error())
+	assert.Equal(t, []Alert{alert}, recorder.alerts)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "text", messages[0]["msgtype"])
+	assert.Equal(t, "daily Warning\n billing: \n"+alert.Text(), messages[0]["text"].(map[string]interface{})["content"])
+
+	_, err = registry.New([]config.Notifier{{Type: "pager"}, {Type: "dingtalk", Name: "ops"}}, "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `notifier 0: unknown type "pager"`)
+	assert.Contains(t, err.Error(), "notifier ops: url is required")
+
+	// 钉钉机器人使用加签或 IP 白名单时可以不设置关键词，消息不加关键词，也不从其他配置文件读取
+	cfg = &config.Config{}
+	cfg.Webhook.URL = robot.URL
+	notifiers, err = registry.FromConfig(cfg, "")
+	require.NoError(t, err)
+	require.NoError(t, notifiers.Notify(ctx, alert))
+	require.Len(t, messages, 2)
+	assert.Equal(t, "daily Warning\n"+alert.Text(), messages[1]["text"].(map[string]interface{})["content"])
+
+	// 机器人返回错误状态
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer broken.Close()
+	assert.Error(t, NewWebHookUserCaseWithDingTalk(broken.URL).WithKeyWord("billing").Notify(ctx, alert))
+
+	// 机器人返回 200，但在 errcode 中返回错误，如关键词不匹配
+	rejected := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"errcode":310000,"errmsg":"keywords not in content"}`))
+	}))
+	defer rejected.Close()
+	err = NewWebHookUserCaseWithDingTalk(rejected.URL).WithKeyWord("billing").Notify(ctx, alert)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "keywords not in content")
+}
@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window 相邻的两个统计周期，上期 [PrevStart, PrevEnd)，本期 [CurStart, CurEnd)
+// 边界为日期，与 _PARTITIONTIME 一致使用 UTC 零点表示
+type Window struct {
+	PrevStart time.Time
+	PrevEnd   time.Time
+	CurStart  time.Time
+	CurEnd    time.Time
+}
+
+// Contains 判断 t 落在上期还是本期
+func (w Window) Contains(t time.Time) (prev, cur bool) {
+	prev = !t.Before(w.PrevStart) && t.Before(w.PrevEnd)
+	cur = !t.Before(w.CurStart) && t.Before(w.CurEnd)
+	return prev, cur
+}
+
+// String 如 "2024-03-04 ~ 2024-03-10 / 2024-03-11 ~ 2024-03-17"，结束日期包含在内
+func (w Window) String() string {
+	day := func(t time.Time) string { return t.Format("2006-01-02") }
+	return day(w.PrevStart) + " ~ " + day(w.PrevEnd.AddDate(0, 0, -1)) + " / " + day(w.CurStart) + " ~ " + day(w.CurEnd.AddDate(0, 0, -1))
+}
+
+// Calendar 在报表时区内计算日/周/月的边界
+// 先取 now 在报表时区的日期，再按日期计算，夏令时切换不会导致跨天
+type Calendar struct {
+	location  *time.Location
+	weekStart time.Weekday
+	clock     Clock
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// NewCalendar timezone 为 IANA 时区名，为空时使用运行环境的本地时区；weekStart 为每周第一天，默认 monday
+func NewCalendar(timezone, weekStart string) (*Calendar, error) {
+	location := time.Local
+	if timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %v", timezone, err)
+		}
+	}
+	start := time.Monday
+	if weekStart != "" {
+		var ok bool
+		if start, ok = weekdays[strings.ToLower(weekStart)]; !ok {
+			return nil, fmt.Errorf("invalid week start %q", weekStart)
+		}
+	}
+	return &Calendar{location: location, weekStart: start, clock: SystemClock}, nil
+}
+
+// WithClock 使用 clock 作为当前时间，默认为系统时间
+func (c *Calendar) WithClock(clock Clock) *Calendar {
+	copied := *c
+	copied.clock = clock
+	return &copied
+}
+
+// Now 当前时间，转换到报表时区；Calendar 因此也可作为 Clock 使用
+func (c *Calendar) Now() time.Time {
+	return c.Local(c.clock.Now())
+}
+
+// ParseDate 解析报表时区的日期 YYYY-MM-DD，返回当天零点
+func (c *Calendar) ParseDate(date string) (time.Time, error) {
+	t, err := time.ParseInLocation("2006-01-02", date, c.location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD: %v", date, err)
+	}
+	return t, nil
+}
+
+// AsOf 固定在 date 当天运行，用于重新生成历史报表
+func (c *Calendar) AsOf(date string) (*Calendar, error) {
+	t, err := c.ParseDate(date)
+	if err != nil {
+		return nil, err
+	}
+	return c.WithClock(FixedClock(t)), nil
+}
+
+// Local now 在报表时区的时间
+func (c *Calendar) Local(now time.Time) time.Time {
+	return now.In(c.location)
+}
+
+// Today now 在报表时区的日期
+func (c *Calendar) Today(now time.Time) time.Time {
+	t := c.Local(now)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// DailyWindow 前天与昨天
+func (c *Calendar) DailyWindow(now time.Time) Window {
+	today := c.Today(now)
+	cur := today.AddDate(0, 0, -1)
+	return Window{PrevStart: cur.AddDate(0, 0, -1), PrevEnd: cur, CurStart: cur, CurEnd: today}
+}
+
+// WeekWindow 最近两个完整的周，本期结束于今天或之前最近的一个 weekStart
+func (c *Calendar) WeekWindow(now time.Time) Window {
+	today := c.Today(now)
+	curEnd := today.AddDate(0, 0, -((int(today.Weekday()) - int(c.weekStart) + 7) % 7))
+	curStart := curEnd.AddDate(0, 0, -7)
+	return Window{PrevStart: curStart.AddDate(0, 0, -7), PrevEnd: curStart, CurStart: curStart, CurEnd: curEnd}
+}
+
+// MonthWindow 上月与本月
+func (c *Calendar) MonthWindow(now time.Time) Window {
+	today := c.Today(now)
+	curStart := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return Window{PrevStart: curStart.AddDate(0, -1, 0), PrevEnd: curStart, CurStart: curStart, CurEnd: curStart.AddDate(0, 1, 0)}
+}
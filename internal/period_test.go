@@ -0,0 +1,182 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalendarWindows(t *testing.T) {
+	d := func(y int, m time.Month, day int) time.Time { return time.Date(y, m, day, 0, 0, 0, 0, time.UTC) }
+	utc := func(value string) time.Time {
+		parsed, err := time.Parse(time.RFC3339, value)
+		require.NoError(t, err)
+		return parsed
+	}
+	window := func(prevStart, curStart, curEnd time.Time) Window {
+		return Window{PrevStart: prevStart, PrevEnd: curStart, CurStart: curStart, CurEnd: curEnd}
+	}
+
+	tests := []struct {
+		name      string
+		timezone  string
+		weekStart string
+		now       string
+		today     time.Time
+		daily     Window
+		week      Window
+		month     Window
+	}{
+		{
+			name:     "shanghai is already in the next month",
+			timezone: "Asia/Shanghai",
+			now:      "2024-03-31T17:00:00Z",
+			today:    d(2024, 4, 1),
+			daily:    window(d(2024, 3, 30), d(2024, 3, 31), d(2024, 4, 1)),
+			week:     window(d(2024, 3, 18), d(2024, 3, 25), d(2024, 4, 1)),
+			month:    window(d(2024, 3, 1), d(2024, 4, 1), d(2024, 5, 1)),
+		},
+		{
+			name:     "pacific is still in the previous month",
+			timezone: "America/Los_Angeles",
+			now:      "2024-04-01T06:00:00Z",
+			today:    d(2024, 3, 31),
+			daily:    window(d(2024, 3, 29), d(2024, 3, 30), d(2024, 3, 31)),
+			week:     window(d(2024, 3, 11), d(2024, 3, 18), d(2024, 3, 25)),
+			month:    window(d(2024, 2, 1), d(2024, 3, 1), d(2024, 4, 1)),
+		},
+		{
+			name:     "pacific just before spring forward",
+			timezone: "America/Los_Angeles",
+			now:      "2024-03-10T09:59:00Z",
+			today:    d(2024, 3, 10),
+			daily:    window(d(2024, 3, 8), d(2024, 3, 9), d(2024, 3, 10)),
+			week:     window(d(2024, 2, 19), d(2024, 2, 26), d(2024, 3, 4)),
+			month:    window(d(2024, 2, 1), d(2024, 3, 1), d(2024, 4, 1)),
+		},
+		{
+			name:     "pacific just after spring forward",
+			timezone: "America/Los_Angeles",
+			now:      "2024-03-10T10:00:00Z",
+			today:    d(2024, 3, 10),
+			daily:    window(d(2024, 3, 8), d(2024, 3, 9), d(2024, 3, 10)),
+			week:     window(d(2024, 2, 19), d(2024, 2, 26), d(2024, 3, 4)),
+			month:    window(d(2024, 2, 1), d(2024, 3, 1), d(2024, 4, 1)),
+		},
+		{
+			name:     "pacific first 01:30 on fall back",
+			timezone: "America/Los_Angeles",
+			now:      "2024-11-03T08:30:00Z",
+			today:    d(2024, 11, 3),
+			daily:    window(d(2024, 11, 1), d(2024, 11, 2), d(2024, 11, 3)),
+			week:     window(d(2024, 10, 14), d(2024, 10, 21), d(2024, 10, 28)),
+			month:    window(d(2024, 10, 1), d(2024, 11, 1), d(2024, 12, 1)),
+		},
+		{
+			name:     "pacific second 01:30 on fall back",
+			timezone: "America/Los_Angeles",
+			now:      "2024-11-03T09:30:00Z",
+			today:    d(2024, 11, 3),
+			daily:    window(d(2024, 11, 1), d(2024, 11, 2), d(2024, 11, 3)),
+			week:     window(d(2024, 10, 14), d(2024, 10, 21), d(2024, 10, 28)),
+			month:    window(d(2024, 10, 1), d(2024, 11, 1), d(2024, 12, 1)),
+		},
+		{
+			name:     "pacific late evening after fall back",
+			timezone: "America/Los_Angeles",
+			now:      "2024-11-04T07:30:00Z",
+			today:    d(2024, 11, 3),
+			daily:    window(d(2024, 11, 1), d(2024, 11, 2), d(2024, 11, 3)),
+			week:     window(d(2024, 10, 14), d(2024, 10, 21), d(2024, 10, 28)),
+			month:    window(d(2024, 10, 1), d(2024, 11, 1), d(2024, 12, 1)),
+		},
+		{
+			name:     "new year in shanghai",
+			timezone: "Asia/Shanghai",
+			now:      "2024-12-31T16:30:00Z",
+			today:    d(2025, 1, 1),
+			daily:    window(d(2024, 12, 30), d(2024, 12, 31), d(2025, 1, 1)),
+			week:     window(d(2024, 12, 16), d(2024, 12, 23), d(2024, 12, 30)),
+			month:    window(d(2024, 12, 1), d(2025, 1, 1), d(2025, 2, 1)),
+		},
+		{
+			name:     "new year's eve in pacific",
+			timezone: "America/Los_Angeles",
+			now:      "2025-01-01T07:59:00Z",
+			today:    d(2024, 12, 31),
+			daily:    window(d(2024, 12, 29), d(2024, 12, 30), d(2024, 12, 31)),
+			week:     window(d(2024, 12, 16), d(2024, 12, 23), d(2024, 12, 30)),
+			month:    window(d(2024, 11, 1), d(2024, 12, 1), d(2025, 1, 1)),
+		},
+		{
+			name:     "leap day month end",
+			timezone: "UTC",
+			now:      "2024-03-01T00:00:00Z",
+			today:    d(2024, 3, 1),
+			daily:    window(d(2024, 2, 28), d(2024, 2, 29), d(2024, 3, 1)),
+			week:     window(d(2024, 2, 12), d(2024, 2, 19), d(2024, 2, 26)),
+			month:    window(d(2024, 2, 1), d(2024, 3, 1), d(2024, 4, 1)),
+		},
+		{
+			name:      "sunday week start on a sunday",
+			timezone:  "UTC",
+			weekStart: "sunday",
+			now:       "2024-03-10T12:00:00Z",
+			today:     d(2024, 3, 10),
+			daily:     window(d(2024, 3, 8), d(2024, 3, 9), d(2024, 3, 10)),
+			week:      window(d(2024, 2, 25), d(2024, 3, 3), d(2024, 3, 10)),
+			month:     window(d(2024, 2, 1), d(2024, 3, 1), d(2024, 4, 1)),
+		},
+		{
+			name:     "monday week start on a monday",
+			timezone: "Asia/Shanghai",
+			now:      "2024-03-10T16:00:00Z",
+			today:    d(2024, 3, 11),
+			daily:    window(d(2024, 3, 9), d(2024, 3, 10), d(2024, 3, 11)),
+			week:     window(d(2024, 2, 26), d(2024, 3, 4), d(2024, 3, 11)),
+			month:    window(d(2024, 2, 1), d(2024, 3, 1), d(2024, 4, 1)),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calendar, err := NewCalendar(tt.timezone, tt.weekStart)
+			require.NoError(t, err)
+			now := utc(tt.now)
+			assert.Equal(t, tt.today, calendar.Today(now))
+			assert.Equal(t, tt.daily, calendar.DailyWindow(now))
+			assert.Equal(t, tt.week, calendar.WeekWindow(now))
+			assert.Equal(t, tt.month, calendar.MonthWindow(now))
+		})
+	}
+}
+
+func TestNewCalendarValidation(t *testing.T) {
+	_, err := NewCalendar("Mars/Olympus_Mons", "")
+	assert.Error(t, err)
+	_, err = NewCalendar("UTC", "funday")
+	assert.Error(t, err)
+	_, err = NewCalendar("", "Sunday")
+	assert.NoError(t, err)
+}
+
+func TestWindowString(t *testing.T) {
+	d := func(day int) time.Time { return time.Date(2024, 3, day, 0, 0, 0, 0, time.UTC) }
+	w := Window{PrevStart: d(4), PrevEnd: d(11), CurStart: d(11), CurEnd: d(18)}
+	assert.Equal(t, "2024-03-04 ~ 2024-03-10 / 2024-03-11 ~ 2024-03-17", w.String())
+}
+
+func TestCalendarAsOf(t *testing.T) {
+	calendar, err := NewCalendar("America/Los_Angeles", "")
+	require.NoError(t, err)
+
+	asOf, err := calendar.AsOf("2024-03-11")
+	require.NoError(t, err)
+	assert.Equal(t, "2024-03-11", reportDate(asOf))
+	assert.Equal(t, "week_usage_2024-03-11.xlsx", reportFileName("week", asOf))
+	assert.Equal(t, "2024-02-26 ~ 2024-03-03 / 2024-03-04 ~ 2024-03-10", asOf.WeekWindow(asOf.Now()).String())
+
+	_, err = calendar.AsOf("2024/03/11")
+	assert.Error(t, err)
+}
@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// 覆盖规则的匹配方式，数值越小优先级越高
+const (
+	matchProject = iota
+	matchProjectRegex
+	matchProjectGlob
+	matchLabel
+)
+
+// RulePolicy 一个周期的默认规则及按项目的覆盖规则
+type RulePolicy struct {
+	defaults  *RuleSet
+	overrides []ruleOverride
+}
+
+type ruleOverride struct {
+	kind  int
+	match func(row ProjectCostComparison) bool
+	rules *RuleSet
+}
+
+// periodRules 取出覆盖项中对应周期的规则集
+type periodRules func(o config.RuleOverride) config.RuleSet
+
+// NewRulePolicy 校验默认规则与覆盖规则，overrides 中未配置该周期的项会被忽略
+func NewRulePolicy(cfg, defaults config.RuleSet, overrides []config.RuleOverride, period periodRules) (*RulePolicy, error) {
+	defaultRules, err := NewRuleSet(cfg, defaults)
+	if err != nil {
+		return nil, err
+	}
+	policy := &RulePolicy{defaults: defaultRules}
+	for i, o := range overrides {
+		set := period(o)
+		if len(set.Rules) == 0 {
+			continue
+		}
+		kind, match, err := overrideMatcher(o)
+		if err != nil {
+			return nil, fmt.Errorf("override %d: %v", i, err)
+		}
+		rules, err := NewRuleSet(set, config.RuleSet{})
+		if err != nil {
+			return nil, fmt.Errorf("override %d: %v", i, err)
+		}
+		policy.overrides = append(policy.overrides, ruleOverride{kind: kind, match: match, rules: rules})
+	}
+	return policy, nil
+}
+
+// RulesFor 按优先级返回项目适用的规则集
+func (p *RulePolicy) RulesFor(row ProjectCostComparison) *RuleSet {
+	var best *ruleOverride
+	for i := range p.overrides {
+		o := &p.overrides[i]
+		if (best == nil || o.kind < best.kind) && o.match(row) {
+			best = o
+		}
+	}
+	if best == nil {
+		return p.defaults
+	}
+	return best.rules
+}
+
+// Check 返回 rows 中被判定为异常的项目
+func (p *RulePolicy) Check(rows []ProjectCostComparison) []Anomaly {
+	var res []Anomaly
+	for _, row := range rows {
+		if fired := p.RulesFor(row).Evaluate(row); len(fired) > 0 {
+			res = append(res, Anomaly{ProjectCostComparison: row, Rules: fired})
+		}
+	}
+	return res
+}
+
+func overrideMatcher(o config.RuleOverride) (int, func(row ProjectCostComparison) bool, error) {
+	set := 0
+	for _, v := range []string{o.Project, o.ProjectGlob, o.ProjectRegex, o.Label} {
+		if v != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return 0, nil, fmt.Errorf("exactly one of project, projectGlob, projectRegex, label is required")
+	}
+
+	switch {
+	case o.Project != "":
+		return matchProject, func(row ProjectCostComparison) bool {
+			return row.Key() == o.Project
+		}, nil
+	case o.ProjectRegex != "":
+		re, err := regexp.Compile(o.ProjectRegex)
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid projectRegex: %v", err)
+		}
+		return matchProjectRegex, func(row ProjectCostComparison) bool {
+			return re.MatchString(row.Key())
+		}, nil
+	case o.ProjectGlob != "":
+		if _, err := path.Match(o.ProjectGlob, ""); err != nil {
+			return 0, nil, fmt.Errorf("invalid projectGlob: %v", err)
+		}
+		return matchProjectGlob, func(row ProjectCostComparison) bool {
+			ok, _ := path.Match(o.ProjectGlob, row.Key())
+			return ok
+		}, nil
+	default:
+		key, value, hasValue := strings.Cut(o.Label, "=")
+		return matchLabel, func(row ProjectCostComparison) bool {
+			v, ok := row.ProjectLabels[key]
+			return ok && (!hasValue || v == value)
+		}, nil
+	}
+}
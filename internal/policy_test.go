@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRulePolicyPrecedence(t *testing.T) {
+	rules := func(name string, relative float64) config.RuleSet {
+		return config.RuleSet{Rules: []config.Rule{{Name: name, RelativeChange: relative}}}
+	}
+	overrides := []config.RuleOverride{
+		{Label: "env=prod", Daily: rules("prod-40%", 0.4)},
+		{ProjectGlob: "sandbox-*", Daily: rules("sandbox-10%", 0.1)},
+		{ProjectRegex: "^sandbox-(ml|data)$", Daily: rules("sandbox-data-20%", 0.2)},
+		{Project: "sandbox-data", Daily: rules("sandbox-data-50%", 0.5)},
+		// 未配置日规则的覆盖项不影响日检查
+		{Project: "billing", Week: rules("billing-week", 0.9)},
+	}
+	policy, err := NewRulePolicy(rules("default-30%", 0.3), config.RuleSet{}, overrides,
+		func(o config.RuleOverride) config.RuleSet { return o.Daily })
+	require.NoError(t, err)
+
+	prod := comparison("prod-app", 100, 135)
+	prod.ProjectLabels = map[string]string{"env": "prod"}
+	sandboxProd := comparison("sandbox-web", 100, 115)
+	sandboxProd.ProjectLabels = map[string]string{"env": "prod"}
+
+	tests := []struct {
+		row  ProjectCostComparison
+		want string
+	}{
+		{comparison("other", 100, 135), "default-30%"},
+		{comparison("billing", 100, 135), "default-30%"},
+		{prod, "prod-40%"},
+		{sandboxProd, "sandbox-10%"},
+		{comparison("sandbox-ml", 100, 115), "sandbox-data-20%"},
+		{comparison("sandbox-data", 100, 115), "sandbox-data-50%"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.row.ProjectID, func(t *testing.T) {
+			assert.Equal(t, tt.want, policy.RulesFor(tt.row).rules[0].Name)
+		})
+	}
+
+	anomalies := policy.Check([]ProjectCostComparison{comparison("other", 100, 135), prod, sandboxProd})
+	require.Len(t, anomalies, 2)
+	assert.Equal(t, "other", anomalies[0].ProjectID)
+	assert.Equal(t, []string{"default-30%"}, anomalies[0].Rules)
+	assert.Equal(t, "sandbox-web", anomalies[1].ProjectID)
+	assert.Equal(t, []string{"sandbox-10%"}, anomalies[1].Rules)
+}
+
+func TestRulePolicyInvalidOverride(t *testing.T) {
+	daily := func(o config.RuleOverride) config.RuleSet { return o.Daily }
+	set := config.RuleSet{Rules: []config.Rule{{RelativeChange: 0.1}}}
+
+	invalid := []config.RuleOverride{
+		{Daily: set},
+		{Project: "a", Label: "env=prod", Daily: set},
+		{ProjectRegex: "(", Daily: set},
+		{ProjectGlob: "[", Daily: set},
+	}
+	for _, o := range invalid {
+		_, err := NewRulePolicy(config.RuleSet{}, defaultDailyRules, []config.RuleOverride{o}, daily)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,238 @@
+package internal
+
+import (
+	"cloud.google.com/go/bigquery"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// tableIDPattern project.dataset.table，project 允许带域名前缀 (example.com:project)
+var tableIDPattern = regexp.MustCompile(`^([a-z0-9.-]+:)?[a-z][a-z0-9-]*[a-z0-9]\.[A-Za-z0-9_]+\.[A-Za-z0-9_-]+$`)
+
+// UsageQuery 参数化后的 BigQuery 查询
+type UsageQuery struct {
+	SQL    string
+	Params []bigquery.QueryParameter
+}
+
+// QueryBuilder 生成账单导出表的用量对比查询，表名经过校验，日期全部通过命名参数传入
+type QueryBuilder struct {
+	tableID   string
+	dimension Dimension
+	costMode  string
+}
+
+func NewQueryBuilder(tableID string) (*QueryBuilder, error) {
+	if !tableIDPattern.MatchString(tableID) {
+		return nil, fmt.Errorf("invalid BigQuery table id: %q", tableID)
+	}
+	return &QueryBuilder{tableID: tableID}, nil
+}
+
+// GroupBy 用量对比查询额外按 dimension 分组，结果的 group_key 列为分组键
+func (b *QueryBuilder) GroupBy(dimension Dimension) *QueryBuilder {
+	return &QueryBuilder{tableID: b.tableID, dimension: dimension, costMode: b.costMode}
+}
+
+// WithCostMode 费用按 gross(只统计 cost，默认) 或 net(cost 加上 credits) 计算
+func (b *QueryBuilder) WithCostMode(mode string) *QueryBuilder {
+	return &QueryBuilder{tableID: b.tableID, dimension: b.dimension, costMode: mode}
+}
+
+// costExpr 每行账单费用的 SQL 表达式
+func (b *QueryBuilder) costExpr() string {
+	if b.costMode == costModeNet {
+		return "(cost + IFNULL((SELECT SUM(c.amount) FROM UNNEST(credits) AS c), 0))"
+	}
+	return "cost"
+}
+
+// DailyQuery 前天与昨天的用量对比
+func (b *QueryBuilder) DailyQuery(w Window) UsageQuery {
+	return b.comparison(w)
+}
+
+// WeekQuery 上上周与上周的用量对比
+func (b *QueryBuilder) WeekQuery(w Window) UsageQuery {
+	return b.comparison(w)
+}
+
+// MonthQuery 上月与本月的用量对比
+func (b *QueryBuilder) MonthQuery(w Window) UsageQuery {
+	return b.comparison(w)
+}
+
+// InvoiceMonthQuery 按 invoice.month 统计上月与本月发票的费用，usage_start_time 早于发票月的费用为延迟到账的调整
+// 发票月结束后几天内导出的费用仍可能计入该月发票，分区范围向后多取 invoiceGraceDays 天
+func (b *QueryBuilder) InvoiceMonthQuery(w Window) UsageQuery {
+	q := b.comparisonQuery(comparisonSpec{
+		inPrevious:  "invoice.month = @prev_month",
+		inCurrent:   "invoice.month = @cur_month",
+		where:       "_PARTITIONTIME >= @prev_start AND _PARTITIONTIME < @partition_end AND invoice.month IN (@prev_month, @cur_month) ",
+		adjustments: true,
+	})
+	q.Params = append([]bigquery.QueryParameter{
+		{Name: "prev_month", Value: invoiceMonth(w.PrevStart)},
+		{Name: "cur_month", Value: invoiceMonth(w.CurStart)},
+		{Name: "prev_start", Value: w.PrevStart},
+		{Name: "partition_end", Value: w.CurEnd.AddDate(0, 0, invoiceGraceDays)},
+	}, q.Params...)
+	return q
+}
+
+// comparison 按 _PARTITIONTIME 划分两期
+func (b *QueryBuilder) comparison(w Window) UsageQuery {
+	q := b.comparisonQuery(comparisonSpec{
+		inPrevious: "_PARTITIONTIME >= @prev_start AND _PARTITIONTIME < @prev_end",
+		inCurrent:  "_PARTITIONTIME >= @cur_start AND _PARTITIONTIME < @cur_end",
+		where: "(_PARTITIONTIME >= @prev_start AND _PARTITIONTIME < @prev_end) " +
+			"OR (_PARTITIONTIME >= @cur_start AND _PARTITIONTIME < @cur_end) ",
+	})
+	q.Params = append([]bigquery.QueryParameter{
+		{Name: "prev_start", Value: w.PrevStart},
+		{Name: "prev_end", Value: w.PrevEnd},
+		{Name: "cur_start", Value: w.CurStart},
+		{Name: "cur_end", Value: w.CurEnd},
+	}, q.Params...)
+	return q
+}
+
+// comparisonSpec 用量对比查询中两期的划分方式
+type comparisonSpec struct {
+	inPrevious string
+	inCurrent  string
+	where      string
+	// adjustments 额外统计 usage_start_time 早于发票月的费用，仅用于按发票月统计
+	adjustments bool
+}
+
+// comparisonQuery 两期费用及按类型拆分的抵扣，抵扣金额为负数，credit_type 缺少 type 时使用抵扣名称
+func (b *QueryBuilder) comparisonQuery(spec comparisonSpec) UsageQuery {
+	previousCost, currentCost := "previous_gross", "current_gross"
+	previousAdjustments, currentAdjustments := "previous_adjustments_gross", "current_adjustments_gross"
+	if b.costMode == costModeNet {
+		previousCost, currentCost = "previous_gross + previous_credits", "current_gross + current_credits"
+		previousAdjustments = "previous_adjustments_gross + previous_adjustment_credits"
+		currentAdjustments = "current_adjustments_gross + current_adjustment_credits"
+	}
+
+	var sql strings.Builder
+	sql.WriteString("WITH usage AS ( ")
+	sql.WriteString("SELECT " + b.dimension.sqlExpr() + " AS group_key, ")
+	sql.WriteString("IFNULL(project.id, '') AS project_id, ")
+	sql.WriteString("project.name AS project_name, ")
+	sql.WriteString("TO_JSON_STRING(project.labels) AS project_labels, ")
+	sql.WriteString("currency, cost, credits, ")
+	if spec.adjustments {
+		sql.WriteString("IFNULL((SELECT SUM(c.amount) FROM UNNEST(credits) AS c), 0) AS credit_sum, ")
+		// 发票月按太平洋时间划分
+		sql.WriteString("usage_start_time < PARSE_TIMESTAMP('%Y%m', invoice.month, '" + invoiceTimezone + "') AS is_adjustment, ")
+	}
+	sql.WriteString(spec.inPrevious + " AS in_previous, ")
+	sql.WriteString(spec.inCurrent + " AS in_current ")
+	sql.WriteString("FROM `" + b.tableID + "` ")
+	sql.WriteString("WHERE " + spec.where)
+	sql.WriteString("), credit_types AS ( ")
+	sql.WriteString("SELECT group_key, project_id, IFNULL(NULLIF(c.type, ''), IFNULL(c.name, '')) AS credit_type, ")
+	sql.WriteString("SUM(IF(in_previous, c.amount, 0)) AS previous_amount, ")
+	sql.WriteString("SUM(IF(in_current, c.amount, 0)) AS current_amount ")
+	sql.WriteString("FROM usage, UNNEST(credits) AS c ")
+	sql.WriteString("GROUP BY group_key, project_id, credit_type ")
+	sql.WriteString("), credit_totals AS ( ")
+	sql.WriteString("SELECT group_key, project_id, ")
+	sql.WriteString("ARRAY_AGG(STRUCT(credit_type, previous_amount, current_amount) ORDER BY credit_type) AS credits, ")
+	sql.WriteString("SUM(previous_amount) AS previous_credits, ")
+	sql.WriteString("SUM(current_amount) AS current_credits ")
+	sql.WriteString("FROM credit_types ")
+	sql.WriteString("GROUP BY group_key, project_id ")
+	sql.WriteString("), costs AS ( ")
+	sql.WriteString("SELECT group_key, project_id, ")
+	sql.WriteString("IFNULL(ANY_VALUE(project_name), '') AS project_name, ")
+	sql.WriteString("IFNULL(ANY_VALUE(project_labels), '') AS project_labels, ")
+	sql.WriteString("IFNULL(ANY_VALUE(currency), '') AS currency, ")
+	if spec.adjustments {
+		sql.WriteString("SUM(IF(in_previous AND is_adjustment, cost, 0)) AS previous_adjustments_gross, ")
+		sql.WriteString("SUM(IF(in_current AND is_adjustment, cost, 0)) AS current_adjustments_gross, ")
+		sql.WriteString("SUM(IF(in_previous AND is_adjustment, credit_sum, 0)) AS previous_adjustment_credits, ")
+		sql.WriteString("SUM(IF(in_current AND is_adjustment, credit_sum, 0)) AS current_adjustment_credits, ")
+	}
+	sql.WriteString("SUM(IF(in_previous, cost, 0)) AS previous_gross, ")
+	sql.WriteString("SUM(IF(in_current, cost, 0)) AS current_gross ")
+	sql.WriteString("FROM usage ")
+	sql.WriteString("GROUP BY group_key, project_id ")
+	sql.WriteString("), joined AS ( ")
+	sql.WriteString("SELECT costs.*, ")
+	sql.WriteString("IFNULL(credit_totals.previous_credits, 0) AS previous_credits, ")
+	sql.WriteString("IFNULL(credit_totals.current_credits, 0) AS current_credits, ")
+	sql.WriteString("credit_totals.credits ")
+	sql.WriteString("FROM costs LEFT JOIN credit_totals USING (group_key, project_id) ")
+	sql.WriteString(") ")
+	sql.WriteString("SELECT group_key, project_id, project_name, project_labels, previous_cost, current_cost, current_cost - previous_cost AS cost_difference, currency, ")
+	if spec.adjustments {
+		sql.WriteString(previousAdjustments + " AS previous_adjustments, " + currentAdjustments + " AS current_adjustments, ")
+		sql.WriteString("previous_adjustment_credits, current_adjustment_credits, ")
+	}
+	sql.WriteString("previous_credits, current_credits, credits ")
+	sql.WriteString("FROM ( ")
+	sql.WriteString("SELECT *, " + previousCost + " AS previous_cost, " + currentCost + " AS current_cost ")
+	sql.WriteString("FROM joined ")
+	sql.WriteString(") AS costs ")
+	sql.WriteString("ORDER BY cost_difference DESC")
+
+	q := UsageQuery{SQL: sql.String()}
+	if b.dimension.Kind == dimensionLabel {
+		q.Params = append(q.Params, bigquery.QueryParameter{Name: "group_label", Value: b.dimension.LabelKey})
+	}
+	return q
+}
+
+// DailyCostQuery [start, end) 内每个项目每天的费用
+func (b *QueryBuilder) DailyCostQuery(start, end time.Time) UsageQuery {
+	var sql strings.Builder
+	sql.WriteString("SELECT IFNULL(project.id, '') AS project_id, ")
+	sql.WriteString("IFNULL(ANY_VALUE(project.name), '') AS project_name, ")
+	sql.WriteString("IFNULL(ANY_VALUE(TO_JSON_STRING(project.labels)), '') AS project_labels, ")
+	sql.WriteString("TIMESTAMP_TRUNC(_PARTITIONTIME, DAY) AS day, ")
+	sql.WriteString("SUM(" + b.costExpr() + ") AS cost, ")
+	sql.WriteString("IFNULL(ANY_VALUE(currency), '') AS currency ")
+	sql.WriteString("FROM `" + b.tableID + "` ")
+	sql.WriteString("WHERE _PARTITIONTIME >= @start AND _PARTITIONTIME < @end ")
+	sql.WriteString("GROUP BY project_id, day ")
+	sql.WriteString("ORDER BY project_id, day")
+
+	return UsageQuery{
+		SQL: sql.String(),
+		Params: []bigquery.QueryParameter{
+			{Name: "start", Value: start},
+			{Name: "end", Value: end},
+		},
+	}
+}
+
+// CostBreakdownQuery 指定项目在两个周期内按服务和 SKU 汇总的费用
+func (b *QueryBuilder) CostBreakdownQuery(w Window, projectIDs []string) UsageQuery {
+	var sql strings.Builder
+	sql.WriteString("SELECT IFNULL(project.id, '') AS project_id, ")
+	sql.WriteString("IFNULL(service.description, '') AS service, ")
+	sql.WriteString("IFNULL(sku.description, '') AS sku, ")
+	sql.WriteString("SUM(IF(_PARTITIONTIME >= @prev_start AND _PARTITIONTIME < @prev_end, " + b.costExpr() + ", 0)) AS previous_cost, ")
+	sql.WriteString("SUM(IF(_PARTITIONTIME >= @cur_start AND _PARTITIONTIME < @cur_end, " + b.costExpr() + ", 0)) AS current_cost ")
+	sql.WriteString("FROM `" + b.tableID + "` ")
+	sql.WriteString("WHERE project.id IN UNNEST(@projects) ")
+	sql.WriteString("AND ((_PARTITIONTIME >= @prev_start AND _PARTITIONTIME < @prev_end) ")
+	sql.WriteString("OR (_PARTITIONTIME >= @cur_start AND _PARTITIONTIME < @cur_end)) ")
+	sql.WriteString("GROUP BY project_id, service, sku")
+
+	return UsageQuery{
+		SQL: sql.String(),
+		Params: []bigquery.QueryParameter{
+			{Name: "prev_start", Value: w.PrevStart},
+			{Name: "prev_end", Value: w.PrevEnd},
+			{Name: "cur_start", Value: w.CurStart},
+			{Name: "cur_end", Value: w.CurEnd},
+			{Name: "projects", Value: projectIDs},
+		},
+	}
+}
@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"cloud.google.com/go/bigquery"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewQueryBuilderValidatesTableID(t *testing.T) {
+	valid := []string{
+		"billing-project.billing_dataset.gcp_billing_export_v1_017DBD_1FB85B_839E84",
+		"example.com:billing-project.dataset.table",
+	}
+	for _, tableID := range valid {
+		_, err := NewQueryBuilder(tableID)
+		assert.NoError(t, err, tableID)
+	}
+
+	invalid := []string{
+		"",
+		"dataset.table",
+		"project.dataset.table`; DROP TABLE x; --",
+		"project.dataset.table name",
+		"Project.dataset.table",
+	}
+	for _, tableID := range invalid {
+		_, err := NewQueryBuilder(tableID)
+		assert.Error(t, err, tableID)
+	}
+}
+
+func TestQueryBuilder(t *testing.T) {
+	builder, err := NewQueryBuilder("billing.export.costs")
+	require.NoError(t, err)
+
+	day := func(m time.Month, d int) time.Time { return time.Date(2024, m, d, 0, 0, 0, 0, time.UTC) }
+	w := Window{PrevStart: day(3, 1), PrevEnd: day(3, 2), CurStart: day(3, 2), CurEnd: day(3, 3)}
+	windowParams := []bigquery.QueryParameter{
+		{Name: "prev_start", Value: day(3, 1)},
+		{Name: "prev_end", Value: day(3, 2)},
+		{Name: "cur_start", Value: day(3, 2)},
+		{Name: "cur_end", Value: day(3, 3)},
+	}
+	base := "WITH usage AS ( " +
+		"SELECT '' AS group_key, " +
+		"IFNULL(project.id, '') AS project_id, " +
+		"project.name AS project_name, " +
+		"TO_JSON_STRING(project.labels) AS project_labels, " +
+		"currency, cost, credits, " +
+		"_PARTITIONTIME >= @prev_start AND _PARTITIONTIME < @prev_end AS in_previous, " +
+		"_PARTITIONTIME >= @cur_start AND _PARTITIONTIME < @cur_end AS in_current " +
+		"FROM `billing.export.costs` " +
+		"WHERE (_PARTITIONTIME >= @prev_start AND _PARTITIONTIME < @prev_end) " +
+		"OR (_PARTITIONTIME >= @cur_start AND _PARTITIONTIME < @cur_end) " +
+		"), credit_types AS ( " +
+		"SELECT group_key, project_id, IFNULL(NULLIF(c.type, ''), IFNULL(c.name, '')) AS credit_type, " +
+		"SUM(IF(in_previous, c.amount, 0)) AS previous_amount, " +
+		"SUM(IF(in_current, c.amount, 0)) AS current_amount " +
+		"FROM usage, UNNEST(credits) AS c " +
+		"GROUP BY group_key, project_id, credit_type " +
+		"), credit_totals AS ( " +
+		"SELECT group_key, project_id, " +
+		"ARRAY_AGG(STRUCT(credit_type, previous_amount, current_amount) ORDER BY credit_type) AS credits, " +
+		"SUM(previous_amount) AS previous_credits, " +
+		"SUM(current_amount) AS current_credits " +
+		"FROM credit_types " +
+		"GROUP BY group_key, project_id " +
+		"), costs AS ( " +
+		"SELECT group_key, project_id, " +
+		"IFNULL(ANY_VALUE(project_name), '') AS project_name, " +
+		"IFNULL(ANY_VALUE(project_labels), '') AS project_labels, " +
+		"IFNULL(ANY_VALUE(currency), '') AS currency, " +
+		"SUM(IF(in_previous, cost, 0)) AS previous_gross, " +
+		"SUM(IF(in_current, cost, 0)) AS current_gross " +
+		"FROM usage " +
+		"GROUP BY group_key, project_id " +
+		"), joined AS ( " +
+		"SELECT costs.*, " +
+		"IFNULL(credit_totals.previous_credits, 0) AS previous_credits, " +
+		"IFNULL(credit_totals.current_credits, 0) AS current_credits, " +
+		"credit_totals.credits " +
+		"FROM costs LEFT JOIN credit_totals USING (group_key, project_id) " +
+		") " +
+		"SELECT group_key, project_id, project_name, project_labels, previous_cost, current_cost, current_cost - previous_cost AS cost_difference, currency, " +
+		"previous_credits, current_credits, credits " +
+		"FROM ( "
+	gross := "SELECT *, previous_gross AS previous_cost, current_gross AS current_cost FROM joined ) AS costs "
+
+	for _, q := range []UsageQuery{builder.DailyQuery(w), builder.WeekQuery(w), builder.MonthQuery(w)} {
+		assert.Equal(t, base+gross+"ORDER BY cost_difference DESC", q.SQL)
+		assert.Equal(t, windowParams, q.Params)
+		assert.NotContains(t, q.SQL, "2024")
+	}
+
+	net := "SELECT *, previous_gross + previous_credits AS previous_cost, current_gross + current_credits AS current_cost FROM joined ) AS costs "
+	assert.Equal(t, base+net+"ORDER BY cost_difference DESC", builder.WithCostMode(costModeNet).WeekQuery(w).SQL)
+}
+
+func TestQueryBuilderGroupBy(t *testing.T) {
+	builder, err := NewQueryBuilder("billing.export.costs")
+	require.NoError(t, err)
+
+	w := Window{}
+	tests := []struct {
+		dimension string
+		expr      string
+	}{
+		{"service", "SELECT IFNULL(NULLIF(service.description, ''), '(none)') AS group_key, "},
+		{"region", "SELECT IFNULL(NULLIF(location.region, ''), '(none)') AS group_key, "},
+		{"billing_account", "SELECT IFNULL(NULLIF(billing_account_id, ''), '(none)') AS group_key, "},
+		{"label:team", "SELECT IFNULL((SELECT l.value FROM UNNEST(labels) AS l WHERE l.key = @group_label LIMIT 1), '(unlabeled)') AS group_key, "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dimension, func(t *testing.T) {
+			dimension, err := ParseDimension(tt.dimension)
+			require.NoError(t, err)
+			q := builder.GroupBy(dimension).WeekQuery(w)
+			assert.Contains(t, q.SQL, tt.expr)
+			assert.Contains(t, q.SQL, "GROUP BY group_key, project_id ")
+			if dimension.Kind == dimensionLabel {
+				assert.Equal(t, bigquery.QueryParameter{Name: "group_label", Value: "team"}, q.Params[len(q.Params)-1])
+			} else {
+				assert.Len(t, q.Params, 4)
+			}
+		})
+	}
+}
+
+func TestDailyCostQuery(t *testing.T) {
+	builder, err := NewQueryBuilder("billing.export.costs")
+	require.NoError(t, err)
+
+	start := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	q := builder.DailyCostQuery(start, end)
+	assert.Equal(t, "SELECT IFNULL(project.id, '') AS project_id, "+
+		"IFNULL(ANY_VALUE(project.name), '') AS project_name, "+
+		"IFNULL(ANY_VALUE(TO_JSON_STRING(project.labels)), '') AS project_labels, "+
+		"TIMESTAMP_TRUNC(_PARTITIONTIME, DAY) AS day, "+
+		"SUM(cost) AS cost, "+
+		"IFNULL(ANY_VALUE(currency), '') AS currency "+
+		"FROM `billing.export.costs` "+
+		"WHERE _PARTITIONTIME >= @start AND _PARTITIONTIME < @end "+
+		"GROUP BY project_id, day "+
+		"ORDER BY project_id, day", q.SQL)
+	assert.Equal(t, []bigquery.QueryParameter{{Name: "start", Value: start}, {Name: "end", Value: end}}, q.Params)
+
+	q = builder.WithCostMode(costModeNet).DailyCostQuery(start, end)
+	assert.Contains(t, q.SQL, "SUM((cost + IFNULL((SELECT SUM(c.amount) FROM UNNEST(credits) AS c), 0))) AS cost, ")
+}
+
+func TestCostBreakdownQuery(t *testing.T) {
+	builder, err := NewQueryBuilder("billing.export.costs")
+	require.NoError(t, err)
+
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+	w := Window{PrevStart: day(1), PrevEnd: day(2), CurStart: day(2), CurEnd: day(3)}
+	q := builder.CostBreakdownQuery(w, []string{"a", "b"})
+	assert.Equal(t, "SELECT IFNULL(project.id, '') AS project_id, "+
+		"IFNULL(service.description, '') AS service, "+
+		"IFNULL(sku.description, '') AS sku, "+
+		"SUM(IF(_PARTITIONTIME >= @prev_start AND _PARTITIONTIME < @prev_end, cost, 0)) AS previous_cost, "+
+		"SUM(IF(_PARTITIONTIME >= @cur_start AND _PARTITIONTIME < @cur_end, cost, 0)) AS current_cost "+
+		"FROM `billing.export.costs` "+
+		"WHERE project.id IN UNNEST(@projects) "+
+		"AND ((_PARTITIONTIME >= @prev_start AND _PARTITIONTIME < @prev_end) "+
+		"OR (_PARTITIONTIME >= @cur_start AND _PARTITIONTIME < @cur_end)) "+
+		"GROUP BY project_id, service, sku", q.SQL)
+	assert.Equal(t, bigquery.QueryParameter{Name: "projects", Value: []string{"a", "b"}}, q.Params[4])
+}
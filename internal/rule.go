@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	combineOr  = "or"
+	combineAnd = "and"
+
+	directionBoth     = "both"
+	directionIncrease = "increase"
+)
+
+// 未配置规则时的默认值，与最初硬编码的阈值一致
+var (
+	defaultDailyRules = config.RuleSet{Rules: []config.Rule{
+		{Name: "daily-relative-30%", RelativeChange: 0.3, MinSpend: 15},
+	}}
+	defaultWeekRules = config.RuleSet{Combine: combineOr, Rules: []config.Rule{
+		{Name: "week-relative-30%", RelativeChange: 0.3},
+		{Name: "week-increase-500", AbsoluteChange: 500, Direction: directionIncrease},
+	}}
+	defaultMonthRules = config.RuleSet{Rules: []config.Rule{
+		{Name: "month-relative-30%", RelativeChange: 0.3},
+	}}
+)
+
+// Anomaly 被规则标记的项目及触发的规则名
+type Anomaly struct {
+	ProjectCostComparison
+	Rules []string
+	// DrillDown 费用变化最大的服务和 SKU，未开启明细时为 nil
+	DrillDown *ProjectDrillDown
+}
+
+// RuleSet 校验后的规则集合
+type RuleSet struct {
+	combine string
+	rules   []config.Rule
+}
+
+// NewRuleSet 校验配置，未配置任何规则时使用 defaults
+func NewRuleSet(cfg, defaults config.RuleSet) (*RuleSet, error) {
+	if len(cfg.Rules) == 0 {
+		cfg.Rules = defaults.Rules
+		if cfg.Combine == "" {
+			cfg.Combine = defaults.Combine
+		}
+	}
+
+	combine := strings.ToLower(cfg.Combine)
+	switch combine {
+	case "":
+		combine = combineOr
+	case combineOr, combineAnd:
+	default:
+		return nil, fmt.Errorf("invalid rule combine %q, expected or/and", cfg.Combine)
+	}
+
+	rules := make([]config.Rule, 0, len(cfg.Rules))
+	for i, rule := range cfg.Rules {
+		rule.Direction = strings.ToLower(rule.Direction)
+		switch rule.Direction {
+		case "":
+			rule.Direction = directionBoth
+		case directionBoth, directionIncrease:
+		default:
+			return nil, fmt.Errorf("rule %d: invalid direction %q, expected increase/both", i, rule.Direction)
+		}
+		if rule.RelativeChange <= 0 && rule.AbsoluteChange <= 0 {
+			return nil, fmt.Errorf("rule %d: relativeChange or absoluteChange is required", i)
+		}
+		if rule.Name == "" {
+			rule.Name = describeRule(rule)
+		}
+		rules = append(rules, rule)
+	}
+	return &RuleSet{combine: combine, rules: rules}, nil
+}
+
+// Evaluate 返回触发的规则名，未判定为异常时返回 nil
+func (rs *RuleSet) Evaluate(row ProjectCostComparison) []string {
+	var fired []string
+	for _, rule := range rs.rules {
+		if matchRule(rule, row) {
+			fired = append(fired, rule.Name)
+		} else if rs.combine == combineAnd {
+			return nil
+		}
+	}
+	return fired
+}
+
+func matchRule(rule config.Rule, row ProjectCostComparison) bool {
+	if rule.MinSpend > 0 && row.PreviousCost < rule.MinSpend && row.CurrentCost < rule.MinSpend {
+		return false
+	}
+	change := math.Abs(row.Delta)
+	if rule.Direction == directionIncrease {
+		change = row.Delta
+	}
+	if rule.RelativeChange > 0 && !(change > row.PreviousCost*rule.RelativeChange) {
+		return false
+	}
+	if rule.AbsoluteChange > 0 && !(change > rule.AbsoluteChange) {
+		return false
+	}
+	return true
+}
+
+// describeRule 为未命名的规则生成名称，如 "increase>30%&>500"
+func describeRule(rule config.Rule) string {
+	var parts []string
+	if rule.RelativeChange > 0 {
+		parts = append(parts, ">"+strconv.FormatFloat(rule.RelativeChange*100, 'f', -1, 64)+"%")
+	}
+	if rule.AbsoluteChange > 0 {
+		parts = append(parts, ">"+strconv.FormatFloat(rule.AbsoluteChange, 'f', -1, 64))
+	}
+	name := "change" + strings.Join(parts, "&")
+	if rule.Direction == directionIncrease {
+		name = "increase" + strings.Join(parts, "&")
+	}
+	if rule.MinSpend > 0 {
+		name += " (spend>=" + strconv.FormatFloat(rule.MinSpend, 'f', -1, 64) + ")"
+	}
+	return name
+}
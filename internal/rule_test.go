@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuleSetEvaluate(t *testing.T) {
+	relative := config.Rule{Name: "relative", RelativeChange: 0.3}
+	absolute := config.Rule{Name: "absolute", AbsoluteChange: 500, Direction: "increase"}
+	floor := config.Rule{Name: "floor", RelativeChange: 0.3, MinSpend: 15}
+	both := config.Rule{Name: "both", RelativeChange: 0.3, AbsoluteChange: 50}
+
+	tests := []struct {
+		name  string
+		rules config.RuleSet
+		row   ProjectCostComparison
+		want  []string
+	}{
+		{"relative increase", config.RuleSet{Rules: []config.Rule{relative}}, comparison("p", 100, 140), []string{"relative"}},
+		{"relative decrease", config.RuleSet{Rules: []config.Rule{relative}}, comparison("p", 100, 60), []string{"relative"}},
+		{"relative within threshold", config.RuleSet{Rules: []config.Rule{relative}}, comparison("p", 100, 120), nil},
+		{"increase only ignores drop", config.RuleSet{Rules: []config.Rule{absolute}}, comparison("p", 2000, 1000), nil},
+		{"increase only", config.RuleSet{Rules: []config.Rule{absolute}}, comparison("p", 2000, 2600), []string{"absolute"}},
+		{"below spend floor", config.RuleSet{Rules: []config.Rule{floor}}, comparison("p", 5, 14), nil},
+		{"above spend floor", config.RuleSet{Rules: []config.Rule{floor}}, comparison("p", 5, 15), []string{"floor"}},
+		{"conditions in a rule are combined with and", config.RuleSet{Rules: []config.Rule{both}}, comparison("p", 100, 140), nil},
+		{"or reports every fired rule", config.RuleSet{Rules: []config.Rule{relative, absolute}}, comparison("p", 1000, 1600), []string{"relative", "absolute"}},
+		{"or fires on any rule", config.RuleSet{Rules: []config.Rule{relative, absolute}}, comparison("p", 100, 140), []string{"relative"}},
+		{"and requires every rule", config.RuleSet{Combine: "AND", Rules: []config.Rule{relative, absolute}}, comparison("p", 100, 140), nil},
+		{"and", config.RuleSet{Combine: "and", Rules: []config.Rule{relative, absolute}}, comparison("p", 1000, 1600), []string{"relative", "absolute"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := NewRuleSet(tt.rules, config.RuleSet{})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, rs.Evaluate(tt.row))
+		})
+	}
+}
+
+func TestNewRuleSet(t *testing.T) {
+	rs, err := NewRuleSet(config.RuleSet{}, defaultWeekRules)
+	require.NoError(t, err)
+	// 未配置规则时使用默认规则
+	require.Len(t, rs.rules, 2)
+	assert.Equal(t, "week-relative-30%", rs.rules[0].Name)
+	assert.Equal(t, "week-increase-500", rs.rules[1].Name)
+	assert.Equal(t, combineOr, rs.combine)
+
+	rs, err = NewRuleSet(config.RuleSet{Rules: []config.Rule{{RelativeChange: 0.1, AbsoluteChange: 20, Direction: "increase", MinSpend: 5}}}, defaultWeekRules)
+	require.NoError(t, err)
+	assert.Equal(t, "increase>10%&>20 (spend>=5)", rs.rules[0].Name)
+
+	_, err = NewRuleSet(config.RuleSet{Combine: "xor", Rules: []config.Rule{{RelativeChange: 0.1}}}, config.RuleSet{})
+	assert.Error(t, err)
+	_, err = NewRuleSet(config.RuleSet{Rules: []config.Rule{{RelativeChange: 0.1, Direction: "down"}}}, config.RuleSet{})
+	assert.Error(t, err)
+	_, err = NewRuleSet(config.RuleSet{Rules: []config.Rule{{MinSpend: 10}}}, config.RuleSet{})
+	assert.Error(t, err)
+}
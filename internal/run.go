@@ -0,0 +1,104 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// 任务状态
+const (
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobSkipped   = "skipped"
+)
+
+// JobResult 单个任务的执行结果，任务在执行过程中填写统计数据
+type JobResult struct {
+	Job string `json:"job"`
+	// AsOf 补跑时任务对应的日期
+	AsOf       string `json:"asOf,omitempty"`
+	Status     string `json:"status"`
+	DurationMs int64  `json:"durationMs"`
+	// RowsChecked 检查或写入报表的项目(分组)数
+	RowsChecked int `json:"rowsChecked"`
+	// Anomalies 发现的异常、基线偏离和预算报警数
+	Anomalies int `json:"anomalies"`
+	// Notifications 实际发送的消息和邮件数，dry run 时为 0
+	Notifications int    `json:"notifications"`
+	Error         string `json:"error,omitempty"`
+}
+
+// RunResult 一次运行的汇总
+type RunResult struct {
+	AsOf   string      `json:"asOf"`
+	DryRun bool        `json:"dryRun"`
+	Jobs   []JobResult `json:"jobs"`
+	// Errors 任务以外的错误，如加载配置失败
+	Errors []string `json:"errors,omitempty"`
+
+	errs []error
+}
+
+// AddError 记录任务以外的错误
+func (r *RunResult) AddError(err error) {
+	r.errs = append(r.errs, err)
+	r.Errors = append(r.Errors, err.Error())
+}
+
+// Err 汇总所有失败任务和其他错误，全部成功时返回 nil
+func (r *RunResult) Err() error {
+	errs := append([]error(nil), r.errs...)
+	for _, job := range r.Jobs {
+		if job.Status == JobFailed {
+			errs = append(errs, fmt.Errorf("job %s: %s", job.Job, job.Error))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// JobFunc 执行一个任务，result 用于填写统计数据
+type JobFunc func(ctx context.Context, result *JobResult) error
+
+// JobRunner 执行注册的任务，一个任务失败不影响其他任务
+type JobRunner struct {
+	jobs map[string]JobFunc
+}
+
+func NewJobRunner() *JobRunner {
+	return &JobRunner{jobs: make(map[string]JobFunc)}
+}
+
+func (r *JobRunner) Register(job string, fn JobFunc) {
+	r.jobs[job] = fn
+}
+
+// Run 按顺序执行 jobs 中的任务，未注册的任务标记为 skipped
+func (r *JobRunner) Run(ctx context.Context, jobs []string) []JobResult {
+	results := make([]JobResult, 0, len(jobs))
+	for _, job := range jobs {
+		result := JobResult{Job: job}
+		fn, ok := r.jobs[job]
+		if !ok {
+			log.Printf("job %s is not registered", job)
+			result.Status = JobSkipped
+			results = append(results, result)
+			continue
+		}
+		log.Printf("running job %s", job)
+		start := time.Now()
+		err := fn(ctx, &result)
+		result.DurationMs = time.Since(start).Milliseconds()
+		if err != nil {
+			log.Printf("job %s failed: %v", job, err)
+			result.Status = JobFailed
+			result.Error = err.Error()
+		} else {
+			result.Status = JobSucceeded
+		}
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobRunner(t *testing.T) {
+	runner := NewJobRunner()
+	runner.Register(JobWeekCheck, func(ctx context.Context, result *JobResult) error {
+		result.RowsChecked = 10
+		return errors.New("query failed")
+	})
+	runner.Register(JobWeekReport, func(ctx context.Context, result *JobResult) error {
+		result.RowsChecked = 10
+		result.Notifications = 2
+		return nil
+	})
+
+	// 一个任务失败不影响后续任务，未注册的任务跳过
+	results := runner.Run(context.Background(), []string{JobWeekCheck, JobMonthCheck, JobWeekReport})
+	require.Len(t, results, 3)
+	assert.Equal(t, JobResult{Job: JobWeekCheck, Status: JobFailed, RowsChecked: 10, Error: "query failed"}, withoutDuration(results[0]))
+	assert.Equal(t, JobResult{Job: JobMonthCheck, Status: JobSkipped}, results[1])
+	assert.Equal(t, JobResult{Job: JobWeekReport, Status: JobSucceeded, RowsChecked: 10, Notifications: 2}, withoutDuration(results[2]))
+
+	run := &RunResult{Jobs: results}
+	assert.EqualError(t, run.Err(), "job weekCheck: query failed")
+	run.AddError(errors.New("storage unavailable"))
+	assert.EqualError(t, run.Err(), "storage unavailable\njob weekCheck: query failed")
+	assert.Equal(t, []string{"storage unavailable"}, run.Errors)
+
+	assert.NoError(t, (&RunResult{Jobs: results[1:]}).Err())
+}
+
+func withoutDuration(r JobResult) JobResult {
+	r.DurationMs = 0
+	return r
+}
@@ -0,0 +1,254 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 可调度的任务
+const (
+	JobDailyCheck  = "dailyCheck"
+	JobWeekCheck   = "weekCheck"
+	JobMonthCheck  = "monthCheck"
+	JobWeekReport  = "weekReport"
+	JobMonthReport = "monthReport"
+	JobBudgetCheck = "budgetCheck"
+)
+
+// Jobs 所有任务，按执行顺序排列
+var Jobs = []string{JobDailyCheck, JobBudgetCheck, JobWeekCheck, JobMonthCheck, JobWeekReport, JobMonthReport}
+
+// IsJob name 是否为可调度的任务
+func IsJob(name string) bool {
+	for _, job := range Jobs {
+		if job == name {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultSchedule 未配置时的执行时间: 每天检查日用量和预算，周二检查周用量，每月 2 号检查月用量，周一生成周/月报表
+var defaultSchedule = map[string]string{
+	JobDailyCheck:  "@daily",
+	JobBudgetCheck: "@daily",
+	JobWeekCheck:   "0 0 * * 2",
+	JobMonthCheck:  "0 0 2 * *",
+	JobWeekReport:  "0 0 * * 1",
+	JobMonthReport: "0 0 * * 1",
+}
+
+// scheduleOff 表达式为 off 时不执行该任务
+const scheduleOff = "off"
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronField 一个字段允许的取值范围和名称
+type cronField struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	// 7 与 0 都表示周日
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// CronExpr cron 表达式: 分 时 日 月 周
+type CronExpr struct {
+	expr                          string
+	minute, hour, dom, month, dow uint64
+	// 日和周都有限制时满足其一即可，与 cron 一致
+	domAny, dowAny bool
+}
+
+// ParseCron 支持 *、数字、名称(jan、mon 等)、a-b、a,b、*/n、a-b/n 以及 @daily、@weekly、@monthly 等
+func ParseCron(expr string) (*CronExpr, error) {
+	spec := strings.TrimSpace(expr)
+	if descriptor, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = descriptor
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression %q, expected 5 fields: minute hour day-of-month month day-of-week", expr)
+	}
+	c := &CronExpr{expr: expr}
+	sets := []*uint64{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", expr, err)
+		}
+		*sets[i] = set
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow = c.dow&^(1<<7) | 1
+	}
+	c.domAny = strings.HasPrefix(fields[2], "*")
+	c.dowAny = strings.HasPrefix(fields[4], "*")
+	return c, nil
+}
+
+func parseCronField(field string, f cronField) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid %s step %q", f.name, part)
+			}
+			rangePart = part[:i]
+		}
+		start, end := f.min, f.max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if start, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = f.value(bounds[1]); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// a/n 表示从 a 开始到最大值
+				end = f.max
+			}
+			if end < start {
+				return 0, fmt.Errorf("invalid %s range %q", f.name, rangePart)
+			}
+		}
+		for v := start; v <= end; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if name != "" && strings.EqualFold(s, name) {
+			return i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s %q, expected %d-%d", f.name, s, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Matches t(按其所在时区)是否为表达式的触发时间，精确到分钟
+func (c *CronExpr) Matches(t time.Time) bool {
+	has := func(set uint64, v int) bool { return set&(1<<uint(v)) != 0 }
+	if !has(c.minute, t.Minute()) || !has(c.hour, t.Hour()) || !has(c.month, int(t.Month())) {
+		return false
+	}
+	dom, dow := has(c.dom, t.Day()), has(c.dow, int(t.Weekday()))
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// FiredBetween (from, to] 内是否有触发时间
+func (c *CronExpr) FiredBetween(from, to time.Time) bool {
+	for t := to.Truncate(time.Minute); t.After(from); t = t.Add(-time.Minute) {
+		if c.Matches(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CronExpr) String() string {
+	return c.expr
+}
+
+// JobSchedule 各任务的执行时间，按报表时区计算
+type JobSchedule struct {
+	jobs     map[string]*CronExpr
+	interval time.Duration
+	calendar *Calendar
+}
+
+// NewJobSchedule 未配置的任务使用默认执行时间，表达式为 off 的任务不执行
+func NewJobSchedule(cfg config.Schedule, calendar *Calendar) (*JobSchedule, error) {
+	interval := 24 * time.Hour
+	if cfg.Interval != "" {
+		var err error
+		if interval, err = time.ParseDuration(cfg.Interval); err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid schedule interval %q", cfg.Interval)
+		}
+	}
+	configured := map[string]string{
+		JobDailyCheck:  cfg.DailyCheck,
+		JobWeekCheck:   cfg.WeekCheck,
+		JobMonthCheck:  cfg.MonthCheck,
+		JobWeekReport:  cfg.WeekReport,
+		JobMonthReport: cfg.MonthReport,
+		JobBudgetCheck: cfg.BudgetCheck,
+	}
+	s := &JobSchedule{jobs: make(map[string]*CronExpr), interval: interval, calendar: calendar}
+	for _, job := range Jobs {
+		expr := configured[job]
+		if expr == "" {
+			expr = defaultSchedule[job]
+		}
+		if strings.EqualFold(expr, scheduleOff) {
+			continue
+		}
+		cron, err := ParseCron(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule for %s: %v", job, err)
+		}
+		s.jobs[job] = cron
+	}
+	return s, nil
+}
+
+// Due 本次运行需要执行的任务: 自上次触发(当前时间减去触发间隔)以来表达式到达过的任务
+func (s *JobSchedule) Due() []string {
+	now := s.calendar.Now()
+	var due []string
+	for _, job := range Jobs {
+		if cron, ok := s.jobs[job]; ok && cron.FiredBetween(now.Add(-s.interval), now) {
+			due = append(due, job)
+		}
+	}
+	return due
+}
+
+// DueOn 当天 [00:00, 次日 00:00) 内表达式到达过的任务，用于指定 asOf 的运行，
+// 此时 calendar 固定在当天零点，按触发间隔计算会把非零点的任务推迟到下一天
+func (s *JobSchedule) DueOn() []string {
+	now := s.calendar.Now()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	end := start.AddDate(0, 0, 1)
+	var due []string
+	for _, job := range Jobs {
+		// FiredBetween 不包含起点，包含终点
+		if cron, ok := s.jobs[job]; ok && cron.FiredBetween(start.Add(-time.Minute), end.Add(-time.Minute)) {
+			due = append(due, job)
+		}
+	}
+	return due
+}
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCron(t *testing.T) {
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		expr    string
+		matches []time.Time
+		misses  []time.Time
+	}{
+		{"@daily", []time.Time{at(3, 11, 0, 0)}, []time.Time{at(3, 11, 0, 1)}},
+		{"30 9 * * mon-fri", []time.Time{at(3, 11, 9, 30), at(3, 15, 9, 30)}, []time.Time{at(3, 16, 9, 30), at(3, 11, 9, 31)}},
+		{"*/15 * * * *", []time.Time{at(3, 11, 7, 0), at(3, 11, 7, 45)}, []time.Time{at(3, 11, 7, 20)}},
+		{"0 0 1,15 * *", []time.Time{at(3, 1, 0, 0), at(3, 15, 0, 0)}, []time.Time{at(3, 2, 0, 0)}},
+		{"0 0 * * 7", []time.Time{at(3, 10, 0, 0)}, []time.Time{at(3, 11, 0, 0)}},
+		{"0 0 1 jan *", []time.Time{at(1, 1, 0, 0)}, []time.Time{at(2, 1, 0, 0)}},
+		// 日和周都有限制时满足其一即可
+		{"0 0 1 * mon", []time.Time{at(3, 1, 0, 0), at(3, 4, 0, 0)}, []time.Time{at(3, 5, 0, 0)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			cron, err := ParseCron(tt.expr)
+			require.NoError(t, err)
+			for _, m := range tt.matches {
+				assert.True(t, cron.Matches(m), m)
+			}
+			for _, m := range tt.misses {
+				assert.False(t, cron.Matches(m), m)
+			}
+		})
+	}
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "@often"} {
+		_, err := ParseCron(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestJobScheduleDue(t *testing.T) {
+	calendar, err := NewCalendar("Asia/Shanghai", "")
+	require.NoError(t, err)
+	dueAt := func(cfg config.Schedule, utc time.Time) []string {
+		schedule, err := NewJobSchedule(cfg, calendar.WithClock(FixedClock(utc)))
+		require.NoError(t, err)
+		return schedule.Due()
+	}
+
+	// 默认时间与原来的固定规则一致，定时器在上海时间 09:00 触发
+	// 2024-03-11 周一
+	assert.Equal(t, []string{JobDailyCheck, JobBudgetCheck, JobWeekReport, JobMonthReport},
+		dueAt(config.Schedule{}, time.Date(2024, 3, 11, 1, 0, 0, 0, time.UTC)))
+	// 2024-04-02 周二，2 号
+	assert.Equal(t, []string{JobDailyCheck, JobBudgetCheck, JobWeekCheck, JobMonthCheck},
+		dueAt(config.Schedule{}, time.Date(2024, 4, 2, 1, 0, 0, 0, time.UTC)))
+	// UTC 12/31 17:00 在上海已是 2024-01-01 周一
+	assert.Equal(t, []string{JobDailyCheck, JobBudgetCheck, JobWeekReport, JobMonthReport},
+		dueAt(config.Schedule{}, time.Date(2023, 12, 31, 17, 0, 0, 0, time.UTC)))
+
+	// 每小时触发时只执行这一小时内到达的任务
+	cfg := config.Schedule{Interval: "1h", DailyCheck: "0 9 * * *", BudgetCheck: "off", MonthReport: "0 9 1 * *"}
+	assert.Equal(t, []string{JobDailyCheck}, dueAt(cfg, time.Date(2024, 3, 12, 1, 30, 0, 0, time.UTC)))
+	assert.Empty(t, dueAt(cfg, time.Date(2024, 3, 12, 2, 30, 0, 0, time.UTC)))
+	assert.Equal(t, []string{JobDailyCheck, JobMonthReport}, dueAt(cfg, time.Date(2024, 4, 1, 1, 0, 0, 0, time.UTC)))
+
+	// 指定 asOf 时执行当天任意时间到达的任务
+	asOf := func(cfg config.Schedule, date string) []string {
+		day, err := calendar.AsOf(date)
+		require.NoError(t, err)
+		schedule, err := NewJobSchedule(cfg, day)
+		require.NoError(t, err)
+		return schedule.DueOn()
+	}
+	cfg = config.Schedule{WeekCheck: "0 9 * * tue", MonthReport: "30 23 * * *"}
+	assert.Equal(t, []string{JobDailyCheck, JobBudgetCheck, JobWeekCheck, JobMonthReport}, asOf(cfg, "2024-03-12"))
+	assert.Equal(t, []string{JobDailyCheck, JobBudgetCheck, JobMonthReport}, asOf(cfg, "2024-03-13"))
+	assert.Equal(t, []string{JobDailyCheck, JobBudgetCheck, JobWeekReport, JobMonthReport}, asOf(config.Schedule{}, "2024-03-11"))
+
+	_, err = NewJobSchedule(config.Schedule{WeekCheck: "every tuesday"}, calendar)
+	assert.Error(t, err)
+	_, err = NewJobSchedule(config.Schedule{Interval: "daily"}, calendar)
+	assert.Error(t, err)
+}
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"context"
+	"time"
+)
+
+// BillingSource 账单数据来源，按项目返回相邻两个周期的用量对比，按用量差降序排列
+type BillingSource interface {
+	DailyUsage(ctx context.Context) ([]ProjectCostComparison, error)
+	WeekUsage(ctx context.Context) ([]ProjectCostComparison, error)
+	MonthUsage(ctx context.Context) ([]ProjectCostComparison, error)
+	// InvoiceMonthUsage 按 invoice.month 统计上月与本月发票的费用，并给出延迟到账的调整
+	InvoiceMonthUsage(ctx context.Context) ([]ProjectCostComparison, error)
+	// DailyCosts 返回 [start, end) 内每个项目每天的费用
+	DailyCosts(ctx context.Context, start, end time.Time) ([]ProjectDailyCost, error)
+	// CostBreakdown 返回指定项目在两个周期内按服务和 SKU 汇总的费用
+	CostBreakdown(ctx context.Context, w Window, projectIDs []string) ([]CostBreakdown, error)
+}
+
+var (
+	_ BillingSource = (*BigQueryUserCase)(nil)
+	_ BillingSource = (*MemorySource)(nil)
+)
+
+// MemorySource 内存数据源，用于离线运行和测试
+type MemorySource struct {
+	Daily []ProjectCostComparison
+	Week  []ProjectCostComparison
+	Month []ProjectCostComparison
+	// InvoiceMonth 按发票月统计的月用量
+	InvoiceMonth []ProjectCostComparison
+	Days         []ProjectDailyCost
+	// Breakdowns SKU 粒度的费用明细
+	Breakdowns []CostBreakdown
+}
+
+func NewMemorySource(daily, week, month []ProjectCostComparison) *MemorySource {
+	return &MemorySource{Daily: daily, Week: week, Month: month}
+}
+
+func (m *MemorySource) DailyUsage(ctx context.Context) ([]ProjectCostComparison, error) {
+	return m.Daily, nil
+}
+
+func (m *MemorySource) WeekUsage(ctx context.Context) ([]ProjectCostComparison, error) {
+	return m.Week, nil
+}
+
+func (m *MemorySource) MonthUsage(ctx context.Context) ([]ProjectCostComparison, error) {
+	return m.Month, nil
+}
+
+func (m *MemorySource) InvoiceMonthUsage(ctx context.Context) ([]ProjectCostComparison, error) {
+	return m.InvoiceMonth, nil
+}
+
+func (m *MemorySource) DailyCosts(ctx context.Context, start, end time.Time) ([]ProjectDailyCost, error) {
+	var res []ProjectDailyCost
+	for _, d := range m.Days {
+		if !d.Day.Before(start) && d.Day.Before(end) {
+			res = append(res, d)
+		}
+	}
+	return res, nil
+}
+
+func (m *MemorySource) CostBreakdown(ctx context.Context, w Window, projectIDs []string) ([]CostBreakdown, error) {
+	var res []CostBreakdown
+	for _, b := range m.Breakdowns {
+		for _, projectID := range projectIDs {
+			if b.ProjectID == projectID {
+				res = append(res, b)
+				break
+			}
+		}
+	}
+	return res, nil
+}
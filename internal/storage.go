@@ -2,23 +2,37 @@ package internal
 
 import (
 	"bytes"
-	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/storage"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/xuri/excelize/v2"
 	"io"
+	"io/fs"
 	"log"
-	"time"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
 type StorageCase struct {
 	bucketName string
 	projectID  string
 	client     *storage.Client
+	// outputDir 不为空时报表和预算状态保存在本地目录，不使用 Cloud Storage
+	outputDir string
+	// clock 决定报表文件名中的日期
+	clock Clock
+	// dryRunDir 不为空时写入的报表和预算状态保存到该目录，读取时优先读取该目录
+	dryRunDir string
 }
 
-func NewStorageCase(ctx context.Context, bucketName, projectID string) (*StorageCase, error) {
+// NewStorageCase clock 为 nil 时使用系统时间
+func NewStorageCase(ctx context.Context, bucketName, projectID string, clock Clock) (*StorageCase, error) {
+	if clock == nil {
+		clock = SystemClock
+	}
 	client, err := storage.NewClient(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("error creating storage client: %v", err)
@@ -27,10 +41,43 @@ func NewStorageCase(ctx context.Context, bucketName, projectID string) (*Storage
 		bucketName: bucketName,
 		projectID:  projectID,
 		client:     client,
+		clock:      clock,
 	}, nil
 }
 
-func (s *StorageCase) storeAsExcel(ctx context.Context, data [][]bigquery.Value, fileName string, headers []string) error {
+// NewLocalStorageCase 报表和预算状态保存在本地目录 outputDir，用于命令行和本地运行，clock 为 nil 时使用系统时间
+func NewLocalStorageCase(outputDir string, clock Clock) (*StorageCase, error) {
+	if clock == nil {
+		clock = SystemClock
+	}
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating output directory: %v", err)
+	}
+	return &StorageCase{outputDir: outputDir, clock: clock}, nil
+}
+
+// WithClock 返回使用 clock 命名报表文件的副本，共用同一个 client
+func (s *StorageCase) WithClock(clock Clock) *StorageCase {
+	copied := *s
+	copied.clock = clock
+	return &copied
+}
+
+// WithDryRun 返回不修改 bucket 或 outputDir 的副本，报表和预算状态写入 dir
+func (s *StorageCase) WithDryRun(dir string) *StorageCase {
+	copied := *s
+	copied.dryRunDir = dir
+	return &copied
+}
+
+// excelSheet 报表中的一个工作表
+type excelSheet struct {
+	name    string
+	headers []string
+	rows    [][]interface{}
+}
+
+func (s *StorageCase) storeAsExcel(ctx context.Context, fileName string, sheets ...excelSheet) error {
 	// 创建新的Excel文件
 	f := excelize.NewFile()
 	defer func() {
@@ -39,25 +86,28 @@ func (s *StorageCase) storeAsExcel(ctx context.Context, data [][]bigquery.Value,
 		}
 	}()
 
-	// 添加工作表
-	sheetName := "Sheet1"
-	index, err := f.NewSheet(sheetName)
-	if err != nil {
-		return fmt.Errorf("error creating new sheet: %v", err)
-	}
-	f.SetActiveSheet(index)
+	for i, sheet := range sheets {
+		// 添加工作表
+		index, err := f.NewSheet(sheet.name)
+		if err != nil {
+			return fmt.Errorf("error creating new sheet: %v", err)
+		}
+		if i == 0 {
+			f.SetActiveSheet(index)
+		}
 
-	// 写入表头
-	for colIndex, header := range headers {
-		cell, _ := excelize.CoordinatesToCellName(colIndex+1, 1) // 表头在第一行
-		f.SetCellValue(sheetName, cell, header)
-	}
+		// 写入表头
+		for colIndex, header := range sheet.headers {
+			cell, _ := excelize.CoordinatesToCellName(colIndex+1, 1) // 表头在第一行
+			f.SetCellValue(sheet.name, cell, header)
+		}
 
-	// 写入数据
-	for rowIndex, row := range data {
-		for colIndex, value := range row {
-			cell, _ := excelize.CoordinatesToCellName(colIndex+1, rowIndex+2)
-			f.SetCellValue(sheetName, cell, value)
+		// 写入数据
+		for rowIndex, row := range sheet.rows {
+			for colIndex, value := range row {
+				cell, _ := excelize.CoordinatesToCellName(colIndex+1, rowIndex+2)
+				f.SetCellValue(sheet.name, cell, value)
+			}
 		}
 	}
 
@@ -66,17 +116,41 @@ func (s *StorageCase) storeAsExcel(ctx context.Context, data [][]bigquery.Value,
 	if err := f.Write(buffer); err != nil {
 		return fmt.Errorf("error writing Excel to buffer: %v", err)
 	}
+	return s.writeObject(ctx, fileName, buffer)
+}
+
+// writeObject 将 content 写入 bucket 中的对象，使用本地目录时写入文件
+func (s *StorageCase) writeObject(ctx context.Context, name string, content io.Reader) error {
+	if dir := s.localDir(); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("error creating directory: %v", err)
+		}
+		data, err := io.ReadAll(content)
+		if err != nil {
+			return fmt.Errorf("error reading content: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			return fmt.Errorf("error writing file: %v", err)
+		}
+		if s.dryRunDir != "" {
+			log.Printf("dry run: file %s written to %s", name, dir)
+		} else {
+			log.Printf("File %s saved to %s", name, dir)
+		}
+		return nil
+	}
 
 	// 获取bucket引用
 	bucket := s.client.Bucket(s.bucketName)
 
 	// 创建新的对象
-	obj := bucket.Object(fileName)
+	obj := bucket.Object(name)
 	writer := obj.NewWriter(ctx)
 
-	// 将Excel文件写入Google Cloud Storage
-	if _, err = io.Copy(writer, buffer); err != nil {
-		return fmt.Errorf("error copying Excel to storage: %v", err)
+	// 写入Google Cloud Storage
+	if _, err := io.Copy(writer, content); err != nil {
+		writer.Close()
+		return fmt.Errorf("error copying content to storage: %v", err)
 	}
 
 	// 关闭writer
@@ -84,14 +158,37 @@ func (s *StorageCase) storeAsExcel(ctx context.Context, data [][]bigquery.Value,
 		return fmt.Errorf("error closing storage writer: %v", err)
 	}
 
-	log.Printf("File %s uploaded to bucket %s", fileName, s.bucketName)
+	log.Printf("File %s uploaded to bucket %s", name, s.bucketName)
 	return nil
 }
 
-func (s *StorageCase) GetExcelFile(ctx context.Context, fileName string) ([]byte, error) {
-	bucket := s.client.Bucket(s.bucketName)
-	obj := bucket.Object(fileName)
-	reader, err := obj.NewReader(ctx)
+// readObject 读取 bucket 中的对象，使用本地目录时读取文件；不存在时返回 errObjectNotExist
+func (s *StorageCase) readObject(ctx context.Context, name string) ([]byte, error) {
+	if s.dryRunDir != "" {
+		// dry run 中写入的文件，如刚生成的报表
+		content, err := os.ReadFile(filepath.Join(s.dryRunDir, name))
+		if err == nil {
+			return content, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("error reading file: %v", err)
+		}
+	}
+	if s.outputDir != "" {
+		content, err := os.ReadFile(filepath.Join(s.outputDir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, errObjectNotExist
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading file: %v", err)
+		}
+		return content, nil
+	}
+
+	reader, err := s.client.Bucket(s.bucketName).Object(name).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, errObjectNotExist
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error reading object from bucket: %v", err)
 	}
@@ -101,28 +198,269 @@ func (s *StorageCase) GetExcelFile(ctx context.Context, fileName string) ([]byte
 	if err != nil {
 		return nil, fmt.Errorf("error reading object content: %v", err)
 	}
+	return content, nil
+}
+
+var errObjectNotExist = errors.New("object does not exist")
+
+// localDir 写入使用的本地目录，为空时写入 bucket
+func (s *StorageCase) localDir() string {
+	if s.dryRunDir != "" {
+		return s.dryRunDir
+	}
+	return s.outputDir
+}
+
+// usageSheets 按项目分组时只有一个工作表；按其他维度分组时第一个工作表为各分组合计，
+// 其后每个分组一个工作表列出该分组下的项目，有抵扣时最后一个工作表为按类型拆分的抵扣。
+// extra 不为空时在项目行后追加列
+func usageSheets(data []ProjectCostComparison, headers []string, extra func(row ProjectCostComparison) []interface{}) []excelSheet {
+	cells := func(row ProjectCostComparison) []interface{} {
+		res := comparisonCells(row)
+		if extra != nil && row.ProjectID != "" {
+			res = append(res, extra(row)...)
+		}
+		return res
+	}
+
+	totals := GroupTotals(data)
+	summary := excelSheet{name: "Sheet1", headers: headers}
+	for _, row := range totals {
+		summary.rows = append(summary.rows, cells(row))
+	}
+	sheets := []excelSheet{summary}
+	used := map[string]bool{strings.ToLower(summary.name): true}
+	grouped := len(data) > 0 && data[0].Group != ""
+	if grouped {
+		sheets[0].headers = append([]string{"分组"}, headers[1:]...)
+		for _, total := range totals {
+			sheet := excelSheet{name: uniqueSheetName(total.Group, used), headers: headers}
+			for _, row := range data {
+				if row.Group == total.Group {
+					row.Group = ""
+					sheet.rows = append(sheet.rows, cells(row))
+				}
+			}
+			sheets = append(sheets, sheet)
+		}
+	}
+	if credits := creditSheet(data, grouped); len(credits.rows) > 0 {
+		credits.name = uniqueSheetName(credits.name, used)
+		sheets = append(sheets, credits)
+	}
+	return sheets
+}
+
+// creditSheet 每个项目按抵扣类型拆分的两期抵扣金额
+func creditSheet(data []ProjectCostComparison, grouped bool) excelSheet {
+	sheet := excelSheet{name: "抵扣明细", headers: []string{"项目id", "项目名称", "抵扣类型", "上期抵扣", "本期抵扣"}}
+	if grouped {
+		sheet.headers = append([]string{"分组"}, sheet.headers...)
+	}
+	for _, row := range data {
+		for _, credit := range row.Credits {
+			cells := []interface{}{row.ProjectID, row.ProjectName, credit.Type, credit.Previous, credit.Current}
+			if grouped {
+				cells = append([]interface{}{row.Group}, cells...)
+			}
+			sheet.rows = append(sheet.rows, cells)
+		}
+	}
+	return sheet
+}
+
+// comparisonCells 第一列为分组键，按项目分组时即项目id，最后两列为两期抵扣合计
+func comparisonCells(row ProjectCostComparison) []interface{} {
+	return []interface{}{row.Key(), row.ProjectName, row.PreviousCost, row.CurrentCost, row.Delta, row.DeltaPercent, row.Currency, row.PreviousCredits, row.CurrentCredits}
+}
+
+// uniqueSheetName 工作表名最长 31 个字符，且不能包含 : \ / ? * [ ]
+func uniqueSheetName(name string, used map[string]bool) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`:\/?*[]`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" {
+		name = "_"
+	}
+	base := []rune(name)
+	if len(base) > 31 {
+		base = base[:31]
+	}
+	candidate := string(base)
+	for i := 2; used[strings.ToLower(candidate)]; i++ {
+		suffix := fmt.Sprintf("~%d", i)
+		trimmed := base
+		if len(trimmed)+len(suffix) > 31 {
+			trimmed = trimmed[:31-len(suffix)]
+		}
+		candidate = string(trimmed) + suffix
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+func (s *StorageCase) GetExcelFile(ctx context.Context, fileName string) ([]byte, error) {
+	content, err := s.readObject(ctx, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", fileName, err)
+	}
+	return content, nil
+}
+
+// ReportURL 当天 kind(week/month/daily) 报表在 Cloud Storage 控制台中的链接，保存在本地目录或 dry run 时为空
+func (s *StorageCase) ReportURL(kind string) string {
+	if s.localDir() != "" {
+		return ""
+	}
+	return "https://storage.cloud.google.com/" + s.bucketName + "/" + reportFileName(kind, s.clock)
+}
 
+// ErrReportNotExist 报表文件不存在
+var ErrReportNotExist = errors.New("report does not exist")
+
+// GetReport 读取 date(YYYY-MM-DD) 生成的 kind(week/month/daily) 报表，不存在时返回 ErrReportNotExist
+func (s *StorageCase) GetReport(ctx context.Context, kind, date string) ([]byte, error) {
+	fileName := kind + "_usage_" + date + ".xlsx"
+	content, err := s.readObject(ctx, fileName)
+	if errors.Is(err, errObjectNotExist) {
+		return nil, ErrReportNotExist
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", fileName, err)
+	}
 	return content, nil
 }
 
-func (s *StorageCase) StoreWeekUsage(ctx context.Context, data [][]bigquery.Value) error {
-	fileName := fmt.Sprintf("week_usage_%s.xlsx", time.Now().Format("2006-01-02"))
+func (s *StorageCase) StoreWeekUsage(ctx context.Context, data []ProjectCostComparison) error {
+	fileName := reportFileName("week", s.clock)
+
+	headers := []string{"项目id", "项目名称", "上上周用量", "上周用量", "周用量差", "变化率(%)", "币种", "上上周抵扣", "上周抵扣"}
+	return s.storeAsExcel(ctx, fileName, usageSheets(data, headers, nil)...)
+}
+
+// StoreMonthUsage forecasts 不为空时在每行后追加该项目的月末预测
+// 按发票月统计时追加延迟调整工作表，reconciliation 不为空时追加按分区与按发票月统计的对账工作表
+func (s *StorageCase) StoreMonthUsage(ctx context.Context, data []ProjectCostComparison, forecasts []ProjectForecast, reconciliation []MonthReconciliation) error {
+	fileName := reportFileName("month", s.clock)
+	headers := []string{"项目id", "项目名称", "上月总用量", "本月已用量", "月用量差", "变化率(%)", "币种", "上月抵扣", "本月抵扣"}
+
+	var extra func(row ProjectCostComparison) []interface{}
+	if len(forecasts) > 0 {
+		headers = append(headers, "预测本月用量", "预测较上月差", "预测变化率(%)", "月度预算", "预测占预算(%)")
+		byProject := make(map[string]ProjectForecast, len(forecasts))
+		for _, f := range forecasts {
+			byProject[f.ProjectID] = f
+		}
+		extra = func(row ProjectCostComparison) []interface{} {
+			f, ok := byProject[row.ProjectID]
+			if !ok {
+				return nil
+			}
+			return []interface{}{f.Forecast, f.ForecastDelta, f.ForecastDeltaPercent, f.Budget, f.BudgetPercent}
+		}
+	}
+	sheets := usageSheets(data, headers, extra)
+	used := make(map[string]bool, len(sheets))
+	for _, sheet := range sheets {
+		used[strings.ToLower(sheet.name)] = true
+	}
+	if adjustments := adjustmentSheet(data); len(adjustments.rows) > 0 {
+		adjustments.name = uniqueSheetName(adjustments.name, used)
+		sheets = append(sheets, adjustments)
+	}
+	if len(reconciliation) > 0 {
+		sheet := reconciliationSheet(reconciliation)
+		sheet.name = uniqueSheetName(sheet.name, used)
+		sheets = append(sheets, sheet)
+	}
+	return s.storeAsExcel(ctx, fileName, sheets...)
+}
+
+// adjustmentSheet 发票中用量早于发票月、延迟到账的费用
+func adjustmentSheet(data []ProjectCostComparison) excelSheet {
+	sheet := excelSheet{name: "延迟调整", headers: []string{"分组", "项目id", "项目名称", "上月发票调整", "本月发票调整", "币种"}}
+	for _, row := range data {
+		if row.PreviousAdjustments == 0 && row.CurrentAdjustments == 0 {
+			continue
+		}
+		sheet.rows = append(sheet.rows, []interface{}{row.Group, row.ProjectID, row.ProjectName, row.PreviousAdjustments, row.CurrentAdjustments, row.Currency})
+	}
+	return sheet
+}
+
+// reconciliationSheet 本月按分区统计与按发票月统计的费用对账
+func reconciliationSheet(rows []MonthReconciliation) excelSheet {
+	sheet := excelSheet{name: "对账", headers: []string{"分组", "项目id", "项目名称", "按分区统计", "按发票月统计", "差额", "其中延迟调整", "币种"}}
+	for _, r := range rows {
+		sheet.rows = append(sheet.rows, []interface{}{r.Group, r.ProjectID, r.ProjectName, r.UsageCost, r.InvoiceCost, r.Difference, r.Adjustments, r.Currency})
+	}
+	return sheet
+}
+
+// StoreDailyUsage 保存日用量异常项目，第二个工作表为各项目费用变化最大的服务和 SKU
+func (s *StorageCase) StoreDailyUsage(ctx context.Context, anomalies []Anomaly) error {
+	fileName := reportFileName("daily", s.clock)
+	sheet := excelSheet{
+		name:    "Sheet1",
+		headers: []string{"项目id", "项目名称", "前天用量", "昨天用量", "日用量差", "变化率(%)", "币种", "前天抵扣", "昨天抵扣", "触发规则"},
+	}
+	details := excelSheet{
+		name:    "明细",
+		headers: []string{"项目id", "类型", "服务", "SKU", "前天用量", "昨天用量", "用量差"},
+	}
+	for _, a := range anomalies {
+		sheet.rows = append(sheet.rows, append(comparisonCells(a.ProjectCostComparison), strings.Join(a.Rules, ", ")))
+		if a.DrillDown == nil {
+			continue
+		}
+		for _, b := range a.DrillDown.Services {
+			details.rows = append(details.rows, []interface{}{b.ProjectID, "服务", b.Service, "", b.PreviousCost, b.CurrentCost, b.Delta})
+		}
+		for _, b := range a.DrillDown.SKUs {
+			details.rows = append(details.rows, []interface{}{b.ProjectID, "SKU", b.Service, b.SKU, b.PreviousCost, b.CurrentCost, b.Delta})
+		}
+	}
+	return s.storeAsExcel(ctx, fileName, sheet, details)
+}
+
+// LoadBudgetState 读取 budget_state_YYYY-MM.json，不存在时返回空状态
+func (s *StorageCase) LoadBudgetState(ctx context.Context, month string) (BudgetState, error) {
+	content, err := s.readObject(ctx, budgetStateFileName(month))
+	if errors.Is(err, errObjectNotExist) {
+		return make(BudgetState), nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	headers := []string{"项目id", "上上周用量", "上周用量", "周用量差"}
-	return s.storeAsExcel(ctx, data, fileName, headers)
+	state := make(BudgetState)
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("error decoding budget state: %v", err)
+	}
+	return state, nil
 }
 
-func (s *StorageCase) StoreMonthUsage(ctx context.Context, data [][]bigquery.Value) error {
-	fileName := fmt.Sprintf("month_usage_%s.xlsx", time.Now().Format("2006-01-02"))
-	headers := []string{"项目id", "上月总用量", "本月已用量", "月用量差"}
-	return s.storeAsExcel(ctx, data, fileName, headers)
+func (s *StorageCase) SaveBudgetState(ctx context.Context, month string, state BudgetState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("error encoding budget state: %v", err)
+	}
+	if err := s.writeObject(ctx, budgetStateFileName(month), bytes.NewReader(content)); err != nil {
+		return fmt.Errorf("error writing budget state: %v", err)
+	}
+	return nil
 }
 
-func (s *StorageCase) StoreDailyUsage(ctx context.Context, data [][]bigquery.Value) error {
-	fileName := fmt.Sprintf("daily_usage_%s.xlsx", time.Now().Format("2006-01-02"))
-	return s.storeAsExcel(ctx, data, fileName, nil)
+func budgetStateFileName(month string) string {
+	return fmt.Sprintf("budget_state_%s.json", month)
 }
 
 func (s *StorageCase) Close() error {
+	if s.client == nil {
+		return nil
+	}
 	return s.client.Close()
 }
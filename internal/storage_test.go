@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestLocalStorageCase(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "reports")
+	storageCase, err := NewLocalStorageCase(dir, FixedClock(time.Date(2024, 3, 11, 9, 0, 0, 0, time.UTC)))
+	require.NoError(t, err)
+	defer storageCase.Close()
+
+	require.NoError(t, storageCase.StoreWeekUsage(ctx, []ProjectCostComparison{comparison("web", 100, 150)}))
+	content, err := storageCase.GetExcelFile(ctx, "week_usage_2024-03-11.xlsx")
+	require.NoError(t, err)
+
+	f, err := excelize.OpenFile(filepath.Join(dir, "week_usage_2024-03-11.xlsx"))
+	require.NoError(t, err)
+	defer f.Close()
+	id, err := f.GetCellValue("Sheet1", "A2")
+	require.NoError(t, err)
+	assert.Equal(t, "web", id)
+	assert.NotEmpty(t, content)
+
+	_, err = storageCase.GetExcelFile(ctx, "month_usage_2024-03-11.xlsx")
+	assert.Error(t, err)
+	report, err := storageCase.GetReport(ctx, "week", "2024-03-11")
+	require.NoError(t, err)
+	assert.Equal(t, content, report)
+	_, err = storageCase.GetReport(ctx, "month", "2024-03-11")
+	assert.ErrorIs(t, err, ErrReportNotExist)
+	assert.Empty(t, storageCase.ReportURL("week"))
+	bucket := &StorageCase{bucketName: "billing-reports", clock: storageCase.clock}
+	assert.Equal(t, "https://storage.cloud.google.com/billing-reports/month_usage_2024-03-11.xlsx", bucket.ReportURL("month"))
+	// dry run 的报表写入本地目录，bucket 中没有对应文件
+	assert.Empty(t, bucket.WithDryRun(t.TempDir()).ReportURL("month"))
+
+	// 预算报警状态
+	state, err := storageCase.LoadBudgetState(ctx, "2024-03")
+	require.NoError(t, err)
+	assert.Empty(t, state)
+	state["web"] = &BudgetAlertState{Thresholds: []float64{50}}
+	require.NoError(t, storageCase.SaveBudgetState(ctx, "2024-03", state))
+	loaded, err := storageCase.LoadBudgetState(ctx, "2024-03")
+	require.NoError(t, err)
+	assert.Equal(t, state, loaded)
+}
+
+func TestLocalStorageCaseDefaultClock(t *testing.T) {
+	storageCase, err := NewLocalStorageCase(t.TempDir(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, reportFileName("week", SystemClock), reportFileName("week", storageCase.clock))
+}
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"errors"
+	"fmt"
+)
+
+// ValidateConfig 不访问任何外部服务，检查配置能否被各个检查、报表和调度使用，返回所有错误
+func ValidateConfig(cfg *config.Config) error {
+	var errs []error
+	check := func(section string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", section, err))
+		}
+	}
+
+	switch cfg.Source.Type {
+	case "", "bigquery":
+		_, err := NewQueryBuilder(cfg.BigQuery.TableID)
+		check("bigQuery", err)
+	case "file":
+		if len(cfg.Source.Files) == 0 {
+			check("source", errors.New("no billing export files"))
+		}
+	default:
+		check("source", fmt.Errorf("unknown billing source type: %s", cfg.Source.Type))
+	}
+
+	_, err := NewNotifierRegistry().FromConfig(cfg, "")
+	check("notifiers", err)
+	_, err = ParseDimension(cfg.Report.GroupBy)
+	check("report.groupBy", err)
+	_, err = ParseCostMode(cfg.Report.CostMode)
+	check("report.costMode", err)
+	calendar, err := NewCalendar(cfg.Report.Timezone, cfg.Report.WeekStart)
+	check("report", err)
+	if calendar == nil {
+		// 后续检查都依赖日历
+		return errors.Join(errs...)
+	}
+
+	_, err = NewUsageCheckCase(nil, cfg, calendar)
+	check("rules", err)
+	if cfg.Baseline.Enabled {
+		_, err = NewBaselineDetector(nil, cfg.Baseline, calendar)
+		check("baseline", err)
+	}
+	if cfg.Forecast.Enabled {
+		_, err = NewForecastCase(nil, cfg, calendar)
+		check("forecast", err)
+	}
+	_, err = NewBudgetCheckCase(nil, nil, cfg, calendar)
+	check("budgets", err)
+	_, err = NewJobSchedule(cfg.Schedule, calendar)
+	check("schedule", err)
+	return errors.Join(errs...)
+}
@@ -0,0 +1,30 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateConfig(t *testing.T) {
+	cfg, err := config.LoadConfig("../config.yaml")
+	require.NoError(t, err)
+	cfg.BigQuery.TableID = "billing-project.billing.gcp_billing_export_v1"
+	assert.NoError(t, ValidateConfig(cfg))
+
+	cfg.Report.CostMode = "list"
+	cfg.Schedule.WeekCheck = "every tuesday"
+	cfg.Budgets = append(cfg.Budgets, config.Budget{Project: "missing-amount"})
+	cfg.Notifiers = append(cfg.Notifiers, config.Notifier{Type: "pager"})
+	err = ValidateConfig(cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "report.costMode: ")
+	assert.Contains(t, err.Error(), "schedule: ")
+	assert.Contains(t, err.Error(), "budgets: ")
+	assert.Contains(t, err.Error(), `notifiers: notifier 0: unknown type "pager"`)
+
+	cfg.Report.Timezone = "Mars/Olympus"
+	assert.Contains(t, ValidateConfig(cfg).Error(), "report: invalid timezone")
+}
@@ -2,10 +2,12 @@ package internal
 
 import (
 	"bytes"
-	"cloud.google.com/go/bigquery"
 	"clzrt.io/billingUsage/internal/config"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strings"
@@ -15,16 +17,20 @@ type WebHookUserCase struct {
 	dingTalk string
 	wechat   string
 	feiShu   string
+	// keyWord 机器人安全设置中的自定义关键词，不为空时加在消息内容前；使用加签或 IP 白名单时可以为空
+	keyWord string
+	// dryRunDir 不为空时消息的请求体写入该目录，不发送
+	dryRunDir string
 }
 
 func NewWebHookUserCaseWithDingTalk(dingTalk string) *WebHookUserCase {
 	return &WebHookUserCase{dingTalk: dingTalk}
 }
 func NewWebHookUserCaseWithWeChat(weChat string) *WebHookUserCase {
-	return &WebHookUserCase{dingTalk: weChat}
+	return &WebHookUserCase{wechat: weChat}
 }
 func NewWebHookUserCaseWithFeiShu(feiShu string) *WebHookUserCase {
-	return &WebHookUserCase{dingTalk: feiShu}
+	return &WebHookUserCase{feiShu: feiShu}
 }
 func NewWebHookUserCase(dingTalk, weChat, feiShu string) *WebHookUserCase {
 	return &WebHookUserCase{
@@ -34,42 +40,186 @@ func NewWebHookUserCase(dingTalk, weChat, feiShu string) *WebHookUserCase {
 	}
 }
 
-func (u *WebHookUserCase) Send2DingTalk(rows [][]bigquery.Value, title string) string {
-	config, err := config.LoadConfig("config_bk.yaml")
+// newDingTalkNotifier 钉钉机器人渠道
+func newDingTalkNotifier(cfg config.Notifier, dryRunDir string) (Notifier, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("url is required")
+	}
+	return NewWebHookUserCaseWithDingTalk(cfg.URL).WithKeyWord(cfg.KeyWord).WithDryRun(dryRunDir), nil
+}
+
+// WithKeyWord 使用指定配置中的关键词
+func (u *WebHookUserCase) WithKeyWord(keyWord string) *WebHookUserCase {
+	copied := *u
+	copied.keyWord = keyWord
+	return &copied
+}
+
+// WithDryRun 返回不发送消息的副本，请求体写入 dir
+func (u *WebHookUserCase) WithDryRun(dir string) *WebHookUserCase {
+	copied := *u
+	copied.dryRunDir = dir
+	return &copied
+}
+
+// Notify 将通知发送到设置了地址的机器人
+func (u *WebHookUserCase) Notify(ctx context.Context, alert Alert) error {
+	var errs []error
+	if u.dingTalk != "" {
+		errs = append(errs, u.postDingTalk(ctx, alert.Title, alert.Text()))
+	}
+	if u.wechat != "" {
+		errs = append(errs, NewWeComNotifier(u.wechat).WithDryRun(u.dryRunDir).Notify(ctx, alert))
+	}
+	if u.feiShu != "" {
+		errs = append(errs, NewFeiShuNotifier(u.feiShu).WithDryRun(u.dryRunDir).Notify(ctx, alert))
+	}
+	return errors.Join(errs...)
+}
 
+// postDingTalk 发送文本消息，设置了关键词时消息中包含关键词
+func (u *WebHookUserCase) postDingTalk(ctx context.Context, title, content string) error {
+	text := title + "\n" + content
+	if u.keyWord != "" {
+		text = title + "\n " + u.keyWord + ": \n" + content
+	}
 	message := map[string]interface{}{
 		"msgtype": "text",
 		"text": map[string]string{
-			"content": title + "\n " + config.Webhook.KeyWord + ": \n" + formatRowsToString(rows),
+			"content": text,
 		},
 	}
+	return postWebhook(ctx, "dingtalk", u.dingTalk, title, message, u.dryRunDir)
+}
+
+// postWebhook 将 message 以 JSON 发送到机器人 webhook，请求失败或返回非 2xx 状态时返回错误
+// dryRunDir 不为空时只将请求体写入该目录
+func postWebhook(ctx context.Context, channel, url, title string, message interface{}, dryRunDir string) error {
 	reqBody, err := json.Marshal(message)
 	if err != nil {
-		log.Println(err)
+		return fmt.Errorf("error encoding %s message: %v", channel, err)
+	}
+	if dryRunDir != "" {
+		path, err := renderFile(dryRunDir, channel+"_*.json", func(w io.Writer) error {
+			_, err := w.Write(reqBody)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("dry run: %s message %q written to %s", channel, title, path)
+		return nil
 	}
-	resp, err := http.Post(u.dingTalk, "application/json", bytes.NewReader(reqBody))
 
-	log.Println(resp)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(reqBody))
 	if err != nil {
-		log.Println(err)
+		return fmt.Errorf("error creating %s request: %v", channel, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending %s message: %v", channel, err)
+	}
+	defer resp.Body.Close()
+	log.Println(resp)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("error sending %s message: %s", channel, resp.Status)
 	}
-	return ""
+	// 机器人在 errcode(钉钉、企业微信)或 code(飞书)中返回关键词不匹配、签名错误、超出频率限制等错误
+	var result struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+		Code    int    `json:"code"`
+		Msg     string `json:"msg"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err == nil {
+		if result.ErrCode != 0 {
+			return fmt.Errorf("error sending %s message: %d %s", channel, result.ErrCode, result.ErrMsg)
+		}
+		if result.Code != 0 {
+			return fmt.Errorf("error sending %s message: %d %s", channel, result.Code, result.Msg)
+		}
+	}
+	return nil
 }
 
-func formatRowsToString(rows [][]bigquery.Value) string {
+func formatRowsToString(rows []Anomaly) string {
 	var result strings.Builder
 	for _, row := range rows {
-		for i, col := range row {
-			switch i {
-			case 0:
-				result.WriteString(fmt.Sprintf("%v: \n", col))
-			case 1:
-				result.WriteString(fmt.Sprintf("\t前天用量: %v", col))
-			case 2:
-				result.WriteString(fmt.Sprintf("\t昨天用量: %v", col))
-			case 3:
-				result.WriteString(fmt.Sprintf("\t用量差: %v", col))
+		result.WriteString(fmt.Sprintf("%s: \n", row.Key()))
+		result.WriteString(fmt.Sprintf("\t前天用量: %.2f", row.PreviousCost))
+		result.WriteString(fmt.Sprintf("\t昨天用量: %.2f", row.CurrentCost))
+		result.WriteString(fmt.Sprintf("\t用量差: %.2f (%.1f%%)", row.Delta, row.DeltaPercent))
+		if row.PreviousCredits != 0 || row.CurrentCredits != 0 {
+			result.WriteString(fmt.Sprintf("\t抵扣: %.2f -> %.2f", row.PreviousCredits, row.CurrentCredits))
+		}
+		if len(row.Rules) > 0 {
+			result.WriteString(fmt.Sprintf("\t触发规则: %s", strings.Join(row.Rules, ", ")))
+		}
+		result.WriteString("\n")
+		if row.DrillDown != nil {
+			for _, b := range row.DrillDown.Services {
+				result.WriteString(fmt.Sprintf("\t  服务 %s: %.2f -> %.2f (%+.2f)\n", b.Service, b.PreviousCost, b.CurrentCost, b.Delta))
 			}
+			for _, b := range row.DrillDown.SKUs {
+				result.WriteString(fmt.Sprintf("\t  SKU %s / %s: %.2f -> %.2f (%+.2f)\n", b.Service, b.SKU, b.PreviousCost, b.CurrentCost, b.Delta))
+			}
+		}
+	}
+	return result.String()
+}
+
+func formatBaselineToString(rows []BaselineAnomaly) string {
+	var result strings.Builder
+	for _, row := range rows {
+		result.WriteString(fmt.Sprintf("%s: \n", row.ProjectID))
+		result.WriteString(fmt.Sprintf("\t%s用量: %.2f", row.Day.Format("2006-01-02"), row.Actual))
+		result.WriteString(fmt.Sprintf("\t预期用量: %.2f", row.Expected))
+		result.WriteString(fmt.Sprintf("\t正常范围: %.2f ~ %.2f", row.Lower, row.Upper))
+		result.WriteString("\n")
+	}
+	return result.String()
+}
+
+// visibleForecasts 预测增长最多的 topN 个项目和超出预算的项目，rows 需按预测增长降序排列
+func visibleForecasts(rows []ProjectForecast, topN int) []ProjectForecast {
+	var visible []ProjectForecast
+	for i, row := range rows {
+		if i < topN || row.OverBudget() {
+			visible = append(visible, row)
+		}
+	}
+	return visible
+}
+
+// formatForecastToString rows 需按预测增长降序排列
+func formatForecastToString(rows []ProjectForecast, topN int) string {
+	var result strings.Builder
+	for _, row := range visibleForecasts(rows, topN) {
+		result.WriteString(fmt.Sprintf("%s: \n", row.ProjectID))
+		result.WriteString(fmt.Sprintf("\t上月用量: %.2f", row.LastMonthCost))
+		result.WriteString(fmt.Sprintf("\t本月已用: %.2f", row.MonthToDate))
+		result.WriteString(fmt.Sprintf("\t预测本月: %.2f", row.Forecast))
+		result.WriteString(fmt.Sprintf("\t预测差: %.2f (%.1f%%)", row.ForecastDelta, row.ForecastDeltaPercent))
+		if row.Budget > 0 {
+			result.WriteString(fmt.Sprintf("\t预算: %.2f (%.1f%%)", row.Budget, row.BudgetPercent))
+		}
+		result.WriteString("\n")
+	}
+	return result.String()
+}
+
+func formatBudgetToString(rows []BudgetAlert) string {
+	var result strings.Builder
+	for _, row := range rows {
+		result.WriteString(fmt.Sprintf("%s: \n", row.Budget))
+		result.WriteString(fmt.Sprintf("\t预算: %.2f", row.Amount))
+		result.WriteString(fmt.Sprintf("\t本月已用: %.2f (%.1f%%)", row.Spent, row.Percent))
+		if len(row.Thresholds) > 0 {
+			result.WriteString(fmt.Sprintf("\t已超过: %s", formatThresholds(row.Thresholds)))
+		}
+		if !row.ExhaustDate.IsZero() {
+			result.WriteString(fmt.Sprintf("\t日均消耗: %.2f, 预计 %s 耗尽预算", row.BurnRate, row.ExhaustDate.Format("2006-01-02")))
 		}
 		result.WriteString("\n")
 	}
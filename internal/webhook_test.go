@@ -0,0 +1,12 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatRowsToString(t *testing.T) {
+	rows := []Anomaly{{ProjectCostComparison: comparison("spike", 100, 150), Rules: []string{"daily-relative-30%"}}}
+	assert.Equal(t, "spike: \n\t前天用量: 100.00\t昨天用量: 150.00\t用量差: 50.00 (50.0%)\t触发规则: daily-relative-30%\n", formatRowsToString(rows))
+}
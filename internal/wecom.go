@@ -0,0 +1,288 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// wecomMaxContentBytes 企业微信机器人 markdown 内容的最大字节数
+const wecomMaxContentBytes = 4096
+
+// wecomPageReserve 为标题后的分页标记 (i/n) 预留的字节数
+const wecomPageReserve = 16
+
+// wecomMaxMessages 一条通知最多拆分的 markdown 消息数，机器人每分钟最多发送 20 条消息，
+// 超出时省略其余项目，在最后一条消息中说明
+const wecomMaxMessages = 10
+
+// wecomOmittedReserve 为省略说明预留的字节数
+const wecomOmittedReserve = 64
+
+// WeComNotifier 企业微信群机器人，发送 markdown 消息，内容超过 4096 字节时拆分为多条，最多 wecomMaxMessages 条
+type WeComNotifier struct {
+	url      string
+	mentions []config.Mention
+	// dryRunDir 不为空时消息的请求体写入该目录，不发送
+	dryRunDir string
+}
+
+func NewWeComNotifier(url string) *WeComNotifier {
+	return &WeComNotifier{url: url}
+}
+
+// newWeComNotifier 企业微信机器人渠道
+func newWeComNotifier(cfg config.Notifier, dryRunDir string) (Notifier, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("url is required")
+	}
+	for i, mention := range cfg.Mentions {
+		if mention.Project == "" {
+			return nil, fmt.Errorf("mention %d: project is required", i)
+		}
+	}
+	return NewWeComNotifier(cfg.URL).WithMentions(cfg.Mentions).WithDryRun(dryRunDir), nil
+}
+
+// WithMentions 通知中出现对应项目时 @ 成员
+func (n *WeComNotifier) WithMentions(mentions []config.Mention) *WeComNotifier {
+	copied := *n
+	copied.mentions = mentions
+	return &copied
+}
+
+// WithDryRun 返回不发送消息的副本，请求体写入 dir
+func (n *WeComNotifier) WithDryRun(dir string) *WeComNotifier {
+	copied := *n
+	copied.dryRunDir = dir
+	return &copied
+}
+
+// Notify 依次发送各条消息，一条失败时仍发送其余消息，返回所有错误
+func (n *WeComNotifier) Notify(ctx context.Context, alert Alert) error {
+	messages := n.messages(alert)
+	var errs []error
+	for i, message := range messages {
+		if err := postWebhook(ctx, "wecom", n.url, alert.Title, message, n.dryRunDir); err != nil {
+			errs = append(errs, fmt.Errorf("message %d/%d: %v", i+1, len(messages), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// wecomSection markdown 消息中的一段，project 为该段对应的项目
+type wecomSection struct {
+	project string
+	content string
+}
+
+// messages 通知对应的请求体，markdown 中按 userid @ 成员；markdown 不支持按手机号 @，
+// 有需要按手机号 @ 的成员时最后追加一条文本消息
+func (n *WeComNotifier) messages(alert Alert) []map[string]interface{} {
+	var messages []map[string]interface{}
+	var mobiles []string
+	sections := wecomSections(alert)
+	limit := wecomMaxContentBytes - len(wecomHeader(alert.Title)) - wecomPageReserve
+	mentionSize := func(projects []string) int {
+		userIDs, _ := n.mentioned(projects)
+		return len(wecomMentionLine(userIDs))
+	}
+	chunks := splitWeComSections(sections, limit, mentionSize)
+	if len(chunks) > wecomMaxMessages {
+		// 为省略说明预留空间后重新拆分
+		chunks = splitWeComSections(sections, limit-wecomOmittedReserve, mentionSize)[:wecomMaxMessages]
+		shown := 0
+		for _, chunk := range chunks {
+			shown += len(chunk)
+		}
+		last := len(chunks) - 1
+		chunks[last] = append(chunks[last], wecomSection{content: fmt.Sprintf("…… 另有 %d 项未展示，请查看报表\n", len(sections)-shown)})
+	}
+	for i, chunk := range chunks {
+		title := alert.Title
+		if len(chunks) > 1 {
+			title = fmt.Sprintf("%s (%d/%d)", alert.Title, i+1, len(chunks))
+		}
+		var content strings.Builder
+		content.WriteString(wecomHeader(title))
+		var projects []string
+		for _, section := range chunk {
+			content.WriteString(section.content)
+			projects = append(projects, section.project)
+		}
+		userIDs, chunkMobiles := n.mentioned(projects)
+		content.WriteString(wecomMentionLine(userIDs))
+		mobiles = appendUnique(mobiles, chunkMobiles...)
+		messages = append(messages, map[string]interface{}{
+			"msgtype":  "markdown",
+			"markdown": map[string]string{"content": content.String()},
+		})
+	}
+	if len(mobiles) > 0 {
+		messages = append(messages, map[string]interface{}{
+			"msgtype": "text",
+			"text": map[string]interface{}{
+				"content":               alert.Title,
+				"mentioned_mobile_list": mobiles,
+			},
+		})
+	}
+	return messages
+}
+
+// mentioned projects 对应需要 @ 的 userid 和手机号，* 匹配任意项目，附加文字不对应项目
+func (n *WeComNotifier) mentioned(projects []string) (userIDs, mobiles []string) {
+	for _, mention := range n.mentions {
+		for _, project := range projects {
+			if project != "" && (mention.Project == "*" || mention.Project == project) {
+				userIDs = appendUnique(userIDs, mention.UserIDs...)
+				mobiles = appendUnique(mobiles, mention.Mobiles...)
+				break
+			}
+		}
+	}
+	return userIDs, mobiles
+}
+
+// splitWeComSections 按顺序将各段分组，每组内容与 @ 行的字节数不超过 limit，
+// 单独一段超过 limit 时按行截断
+func splitWeComSections(sections []wecomSection, limit int, mentionSize func(projects []string) int) [][]wecomSection {
+	if len(sections) == 0 {
+		return [][]wecomSection{nil}
+	}
+	var chunks [][]wecomSection
+	var current []wecomSection
+	var projects []string
+	size := 0
+	for _, section := range sections {
+		if len(current) > 0 && size+len(section.content)+mentionSize(append(projects, section.project)) > limit {
+			chunks = append(chunks, current)
+			current, projects, size = nil, nil, 0
+		}
+		if maxBytes := limit - mentionSize([]string{section.project}); len(section.content) > maxBytes {
+			section.content = truncateLines(section.content, maxBytes-len("…\n")) + "…\n"
+		}
+		current = append(current, section)
+		projects = append(projects, section.project)
+		size += len(section.content)
+	}
+	return append(chunks, current)
+}
+
+// truncateLines 截断到不超过 n 字节，只在换行处截断，以免拆开 <font> 等 markdown 标记；
+// 第一行就超过 n 字节时按字符截断
+func truncateLines(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if len(s) <= n {
+		return s
+	}
+	if end := strings.LastIndex(s[:n], "\n"); end >= 0 {
+		return s[:end+1]
+	}
+	return truncateUTF8(s, n)
+}
+
+// truncateUTF8 截断到不超过 n 字节，不拆分字符
+func truncateUTF8(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+func wecomHeader(title string) string {
+	return "### " + title + "\n"
+}
+
+func wecomMentionLine(userIDs []string) string {
+	if len(userIDs) == 0 {
+		return ""
+	}
+	mentions := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		mentions[i] = "<@" + userID + ">"
+	}
+	return strings.Join(mentions, " ") + "\n"
+}
+
+// wecomSections 通知内容的 markdown 格式，每个项目一段
+func wecomSections(alert Alert) []wecomSection {
+	var sections []wecomSection
+	if alert.Message != "" {
+		sections = append(sections, wecomSection{content: alert.Message + "\n"})
+	}
+	for _, row := range alert.Anomalies {
+		var content strings.Builder
+		content.WriteString(fmt.Sprintf("**%s**\n", row.Key()))
+		content.WriteString(fmt.Sprintf("> 上期: %.2f 本期: %.2f 差: <font color=\"warning\">%+.2f (%.1f%%)</font>\n", row.PreviousCost, row.CurrentCost, row.Delta, row.DeltaPercent))
+		if row.PreviousCredits != 0 || row.CurrentCredits != 0 {
+			content.WriteString(fmt.Sprintf("> 抵扣: %.2f -> %.2f\n", row.PreviousCredits, row.CurrentCredits))
+		}
+		if len(row.Rules) > 0 {
+			content.WriteString(fmt.Sprintf("> 触发规则: %s\n", strings.Join(row.Rules, ", ")))
+		}
+		if row.DrillDown != nil {
+			for _, b := range row.DrillDown.Services {
+				content.WriteString(fmt.Sprintf("> 服务 %s: %.2f -> %.2f (%+.2f)\n", b.Service, b.PreviousCost, b.CurrentCost, b.Delta))
+			}
+			for _, b := range row.DrillDown.SKUs {
+				content.WriteString(fmt.Sprintf("> SKU %s / %s: %.2f -> %.2f (%+.2f)\n", b.Service, b.SKU, b.PreviousCost, b.CurrentCost, b.Delta))
+			}
+		}
+		sections = append(sections, wecomSection{project: row.Key(), content: content.String()})
+	}
+	for _, row := range alert.Baseline {
+		content := fmt.Sprintf("**%s**\n> %s用量: <font color=\"warning\">%.2f</font> 预期: %.2f 正常范围: %.2f ~ %.2f\n",
+			row.ProjectID, row.Day.Format("2006-01-02"), row.Actual, row.Expected, row.Lower, row.Upper)
+		sections = append(sections, wecomSection{project: row.ProjectID, content: content})
+	}
+	for _, row := range visibleForecasts(alert.Forecasts, alert.ForecastTopN) {
+		content := fmt.Sprintf("**%s**\n> 上月: %.2f 本月已用: %.2f 预测本月: %.2f 预测差: %+.2f (%.1f%%)\n",
+			row.ProjectID, row.LastMonthCost, row.MonthToDate, row.Forecast, row.ForecastDelta, row.ForecastDeltaPercent)
+		if row.Budget > 0 {
+			content += fmt.Sprintf("> 预算: %.2f (%.1f%%)\n", row.Budget, row.BudgetPercent)
+		}
+		sections = append(sections, wecomSection{project: row.ProjectID, content: content})
+	}
+	for _, row := range alert.Budgets {
+		content := fmt.Sprintf("**%s**\n> 预算: %.2f 本月已用: <font color=\"warning\">%.2f (%.1f%%)</font>\n", row.Budget, row.Amount, row.Spent, row.Percent)
+		if len(row.Thresholds) > 0 {
+			content += fmt.Sprintf("> 已超过: %s\n", formatThresholds(row.Thresholds))
+		}
+		if !row.ExhaustDate.IsZero() {
+			content += fmt.Sprintf("> 日均消耗: %.2f, 预计 %s 耗尽预算\n", row.BurnRate, row.ExhaustDate.Format("2006-01-02"))
+		}
+		sections = append(sections, wecomSection{project: row.Budget, content: content})
+	}
+	return sections
+}
+
+// appendUnique 追加 values 中未出现过的值，保持顺序
+func appendUnique(list []string, values ...string) []string {
+	for _, value := range values {
+		if !containsString(list, value) {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
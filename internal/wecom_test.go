@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"clzrt.io/billingUsage/internal/config"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWeComNotifier(t *testing.T) {
+	ctx := context.Background()
+	var received []map[string]interface{}
+	robot := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&message))
+		received = append(received, message)
+		fmt.Fprint(w, `{"errcode":0,"errmsg":"ok"}`)
+	}))
+	defer robot.Close()
+
+	notifiers, err := NewNotifierRegistry().New([]config.Notifier{{
+		Type: "wecom",
+		URL:  robot.URL,
+		Mentions: []config.Mention{
+			{Project: "project-0", UserIDs: []string{"zhangsan"}},
+			{Project: "project-99", UserIDs: []string{"lisi"}, Mobiles: []string{"13800000000"}},
+		},
+	}}, "")
+	require.NoError(t, err)
+
+	// 100 个项目超过 4096 字节，拆分为多条消息
+	var anomalies []Anomaly
+	for i := 0; i < 100; i++ {
+		anomalies = append(anomalies, Anomaly{ProjectCostComparison: comparison(fmt.Sprintf("project-%d", i), 100, 150), Rules: []string{"daily-relative-30%"}})
+	}
+	require.NoError(t, notifiers.Notify(ctx, Alert{Title: "daily Warning", Anomalies: anomalies}))
+	require.Greater(t, len(received), 2)
+
+	markdown := received[:len(received)-1]
+	var all strings.Builder
+	for i, message := range markdown {
+		assert.Equal(t, "markdown", message["msgtype"])
+		content := message["markdown"].(map[string]interface{})["content"].(string)
+		assert.LessOrEqual(t, len(content), wecomMaxContentBytes)
+		assert.True(t, strings.HasPrefix(content, fmt.Sprintf("### daily Warning (%d/%d)\n", i+1, len(markdown))), content)
+		all.WriteString(content)
+	}
+	for i := 0; i < 100; i++ {
+		assert.Contains(t, all.String(), fmt.Sprintf("**project-%d**\n", i))
+	}
+	// 只在包含对应项目的消息中 @ 成员
+	first := markdown[0]["markdown"].(map[string]interface{})["content"].(string)
+	last := markdown[len(markdown)-1]["markdown"].(map[string]interface{})["content"].(string)
+	assert.True(t, strings.HasSuffix(first, "<@zhangsan>\n"))
+	assert.True(t, strings.HasSuffix(last, "<@lisi>\n"))
+	assert.NotContains(t, first, "<@lisi>")
+
+	// markdown 不支持按手机号 @，追加一条文本消息
+	mobile := received[len(received)-1]
+	assert.Equal(t, "text", mobile["msgtype"])
+	assert.Equal(t, []interface{}{"13800000000"}, mobile["text"].(map[string]interface{})["mentioned_mobile_list"])
+
+	// 没有匹配的项目时不 @ 成员，内容较短时不分页
+	received = nil
+	require.NoError(t, notifiers.Notify(ctx, Alert{Title: "周用量无异常"}))
+	require.Len(t, received, 1)
+	assert.Equal(t, "### 周用量无异常\n", received[0]["markdown"].(map[string]interface{})["content"])
+
+	// 单个项目超过限制时按行截断，不拆开 <font> 标记
+	huge := Anomaly{ProjectCostComparison: comparison("huge", 100, 150), Rules: []string{strings.Repeat("规则", 2000)}}
+	messages := NewWeComNotifier(robot.URL).messages(Alert{Title: "daily Warning", Anomalies: []Anomaly{huge}})
+	require.Len(t, messages, 1)
+	content := messages[0]["markdown"].(map[string]string)["content"]
+	assert.LessOrEqual(t, len(content), wecomMaxContentBytes)
+	assert.True(t, strings.HasSuffix(content, "</font>\n…\n"), content)
+	assert.NotContains(t, content, "触发规则")
+
+	// 项目过多时最多发送 wecomMaxMessages 条，其余项目省略
+	anomalies = nil
+	for i := 0; i < 1000; i++ {
+		anomalies = append(anomalies, Anomaly{ProjectCostComparison: comparison(fmt.Sprintf("project-%d", i), 100, 150)})
+	}
+	messages = NewWeComNotifier(robot.URL).messages(Alert{Title: "daily Warning", Anomalies: anomalies})
+	require.Len(t, messages, wecomMaxMessages)
+	shown := 0
+	for _, message := range messages {
+		content := message["markdown"].(map[string]string)["content"]
+		assert.LessOrEqual(t, len(content), wecomMaxContentBytes)
+		shown += strings.Count(content, "**project-")
+	}
+	last = messages[wecomMaxMessages-1]["markdown"].(map[string]string)["content"]
+	assert.True(t, strings.HasSuffix(last, fmt.Sprintf("…… 另有 %d 项未展示，请查看报表\n", 1000-shown)), last)
+
+	// 机器人在 errcode 中返回错误
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"errcode":93000,"errmsg":"invalid webhook url"}`)
+	}))
+	defer rejecting.Close()
+	err = NewWeComNotifier(rejecting.URL).Notify(ctx, Alert{Title: "daily Warning"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "93000 invalid webhook url")
+
+	// 一条消息失败时仍发送其余消息
+	requests := 0
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, `{"errcode":0,"errmsg":"ok"}`)
+	}))
+	defer flaky.Close()
+	err = NewWeComNotifier(flaky.URL).Notify(ctx, Alert{Title: "daily Warning", Anomalies: anomalies[:100]})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "message 1/")
+	assert.Greater(t, requests, 2)
+
+	_, err = NewNotifierRegistry().New([]config.Notifier{{Type: "wecom", URL: robot.URL, Mentions: []config.Mention{{UserIDs: []string{"zhangsan"}}}}}, "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,291 @@
+package billingUsage
+
+import (
+	"clzrt.io/billingUsage/internal"
+	"clzrt.io/billingUsage/internal/config"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// usageJobs 一次运行中各任务共用的配置和依赖
+type usageJobs struct {
+	cfg         *config.Config
+	calendar    *internal.Calendar
+	source      internal.BillingSource
+	checkCase   *internal.UsageCheckCase
+	storageCase *internal.StorageCase
+	notifier    internal.Notifiers
+	emailCase   *internal.EmailUseCase
+	// dryRun 只执行查询和检查，不发送通知和邮件，不保存报表和预算报警状态
+	dryRun bool
+}
+
+// register 注册所有任务
+func (j *usageJobs) register(runner *internal.JobRunner) {
+	runner.Register(internal.JobDailyCheck, j.dailyCheck)
+	runner.Register(internal.JobBudgetCheck, j.budgetCheck)
+	runner.Register(internal.JobWeekCheck, j.weekCheck)
+	runner.Register(internal.JobMonthCheck, j.monthCheck)
+	runner.Register(internal.JobWeekReport, j.weekReport)
+	runner.Register(internal.JobMonthReport, j.monthReport)
+}
+
+// notify 将通知发送到所有渠道，按发送成功的渠道计数，dry run 时只记录标题
+func (j *usageJobs) notify(ctx context.Context, result *internal.JobResult, alert internal.Alert) error {
+	if j.dryRun {
+		log.Printf("dry run: skip sending %s", alert.Title)
+		return nil
+	}
+	delivered, err := j.notifier.Send(ctx, alert)
+	result.Notifications += delivered
+	if err != nil {
+		return fmt.Errorf("error sending %s: %v", alert.Title, err)
+	}
+	return nil
+}
+
+// deliver 保存报表，dry run 时只记录
+func (j *usageJobs) deliver(action string, fn func() error) error {
+	if j.dryRun {
+		log.Printf("dry run: skip %s", action)
+		return nil
+	}
+	return fn()
+}
+
+// sendReport 将报表发送给所有收件人，一个收件人失败不影响其他收件人
+func (j *usageJobs) sendReport(ctx context.Context, result *internal.JobResult, report string, send func(ctx context.Context, recipient string) error) error {
+	var errs []error
+	for _, recipient := range j.cfg.Recipients {
+		if j.dryRun {
+			log.Printf("dry run: skip sending %s usage report to %s", report, recipient)
+			continue
+		}
+		if err := send(ctx, recipient); err != nil {
+			errs = append(errs, fmt.Errorf("error sending %s usage report to %s: %v", report, recipient, err))
+			continue
+		}
+		result.Notifications++
+	}
+	return errors.Join(errs...)
+}
+
+// dailyCheck 日用量检查，异常项目附服务/SKU 明细，之后进行滚动基线检查
+func (j *usageJobs) dailyCheck(ctx context.Context, result *internal.JobResult) error {
+	daily, err := j.checkCase.DailyCheck(ctx)
+	if err != nil {
+		return err
+	}
+	dailyUsage := daily.Anomalies
+	result.RowsChecked = daily.Checked
+	result.Anomalies = len(dailyUsage)
+
+	var errs []error
+	reportURL, err := j.storeDailyReport(ctx, dailyUsage)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	// 日用量有异常才发送
+	title := "daily Warning"
+	if dailyUsage == nil {
+		title = "日用量无异常"
+		log.Println("日用量无异常")
+	}
+	if err := j.notify(ctx, result, internal.Alert{Title: title, Anomalies: dailyUsage, ReportURL: reportURL}); err != nil {
+		errs = append(errs, err)
+	}
+
+	// 滚动基线检查，与过去 N 天(可按星期几)的费用比较
+	if j.cfg.Baseline.Enabled {
+		baselineDetector, err := internal.NewBaselineDetector(j.source, j.cfg.Baseline, j.calendar)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid baseline configuration: %v", err))
+		} else if baselineAnomalies, err := baselineDetector.Check(ctx); err != nil {
+			errs = append(errs, err)
+		} else if len(baselineAnomalies) > 0 {
+			result.Anomalies += len(baselineAnomalies)
+			if err := j.notify(ctx, result, internal.Alert{Title: "日用量偏离基线", Baseline: baselineAnomalies}); err != nil {
+				errs = append(errs, err)
+			}
+		} else {
+			log.Println("日用量未偏离基线")
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// storeDailyReport 开启明细时查询异常项目的服务/SKU 明细，附在 anomalies 中并保存为日报表，返回报表链接
+func (j *usageJobs) storeDailyReport(ctx context.Context, anomalies []internal.Anomaly) (string, error) {
+	if !j.cfg.DrillDown.Enabled || len(anomalies) == 0 {
+		return "", nil
+	}
+	topN := j.cfg.DrillDown.TopN
+	if topN <= 0 {
+		topN = 5
+	}
+	if err := j.checkCase.DailyDrillDown(ctx, anomalies, topN); err != nil {
+		return "", fmt.Errorf("error querying cost breakdown: %v", err)
+	}
+	if err := j.deliver("storing daily usage", func() error { return j.storageCase.StoreDailyUsage(ctx, anomalies) }); err != nil {
+		return "", fmt.Errorf("error storing daily usage: %v", err)
+	}
+	return j.storageCase.ReportURL("daily"), nil
+}
+
+// budgetCheck 预算检查，每个报警百分比每月只发送一次
+func (j *usageJobs) budgetCheck(ctx context.Context, result *internal.JobResult) error {
+	if len(j.cfg.Budgets) == 0 {
+		return nil
+	}
+	var store internal.BudgetStateStore = j.storageCase
+	if j.dryRun {
+		store = internal.ReadOnlyBudgetStateStore(store)
+	}
+	budgetCase, err := internal.NewBudgetCheckCase(j.source, store, j.cfg, j.calendar)
+	if err != nil {
+		return fmt.Errorf("invalid budget configuration: %v", err)
+	}
+	result.RowsChecked = len(j.cfg.Budgets)
+	budget, err := budgetCase.Check(ctx)
+	if err != nil {
+		return fmt.Errorf("error checking budgets: %v", err)
+	}
+	if len(budget.Alerts) == 0 {
+		return nil
+	}
+	result.Anomalies = len(budget.Alerts)
+	// 报警发送成功后才保存报警状态，发送失败时下次运行再次报警
+	if err := j.notify(ctx, result, internal.Alert{Title: "预算报警", Budgets: budget.Alerts}); err != nil {
+		return err
+	}
+	return budgetCase.Save(ctx, budget)
+}
+
+// weekCheck 检查周用量数据异常，有异常时保存周用量报表并在通知中附链接
+func (j *usageJobs) weekCheck(ctx context.Context, result *internal.JobResult) error {
+	week, err := j.checkCase.WeekCheck(ctx)
+	if err != nil {
+		return err
+	}
+	result.RowsChecked = week.Checked
+	result.Anomalies = len(week.Anomalies)
+	title := "周用量异常"
+	if week.Anomalies == nil {
+		title = "周用量无异常"
+		log.Println("周用量无异常")
+	}
+	return j.notifyCheck(ctx, result, "week", week, title, func() error { return j.storageCase.StoreWeekUsage(ctx, week.Rows) })
+}
+
+// monthCheck 检查月用量数据异常，有异常时保存月用量报表并在通知中附链接
+func (j *usageJobs) monthCheck(ctx context.Context, result *internal.JobResult) error {
+	month, err := j.checkCase.MonthCheck(ctx)
+	if err != nil {
+		return err
+	}
+	result.RowsChecked = month.Checked
+	result.Anomalies = len(month.Anomalies)
+	title := "月用量异常"
+	if month.Anomalies == nil {
+		title = "月用量无异常"
+		log.Println("月用量无异常")
+	}
+	// 同一天的 monthReport 在之后执行，会以附带预测和对账的报表覆盖
+	return j.notifyCheck(ctx, result, "month", month, title, func() error { return j.storageCase.StoreMonthUsage(ctx, month.Rows, nil, nil) })
+}
+
+// notifyCheck 有异常时用 store 保存 kind 报表，发送附报表链接的通知；保存失败时仍发送不带链接的通知
+func (j *usageJobs) notifyCheck(ctx context.Context, result *internal.JobResult, kind string, check internal.CheckResult, title string, store func() error) error {
+	var errs []error
+	var reportURL string
+	if len(check.Anomalies) > 0 {
+		if err := j.deliver("storing "+kind+" usage", store); err != nil {
+			errs = append(errs, fmt.Errorf("error storing %s usage: %v", kind, err))
+		} else {
+			reportURL = j.storageCase.ReportURL(kind)
+		}
+	}
+	if err := j.notify(ctx, result, internal.Alert{Title: title, Anomalies: check.Anomalies, ReportURL: reportURL}); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// weekReport 统计上周与上上周用量，保存报表并发送邮件
+func (j *usageJobs) weekReport(ctx context.Context, result *internal.JobResult) error {
+	if err := j.storeWeekReport(ctx, result); err != nil {
+		return err
+	}
+	return j.sendReport(ctx, result, "week", j.emailCase.SendWeekUsageReport)
+}
+
+// monthReport 统计本月与上月用量，保存报表并发送月末预测和邮件
+func (j *usageJobs) monthReport(ctx context.Context, result *internal.JobResult) error {
+	forecasts, err := j.storeMonthReport(ctx, result)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	if len(forecasts) > 0 {
+		topN := j.cfg.Forecast.TopN
+		if topN <= 0 {
+			topN = 10
+		}
+		if err := j.notify(ctx, result, internal.Alert{Title: "月末用量预测", Forecasts: forecasts, ForecastTopN: topN, ReportURL: j.storageCase.ReportURL("month")}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	errs = append(errs, j.sendReport(ctx, result, "month", j.emailCase.SendMonthUsageReport))
+	return errors.Join(errs...)
+}
+
+// storeWeekReport 生成并保存周用量报表
+func (j *usageJobs) storeWeekReport(ctx context.Context, result *internal.JobResult) error {
+	weekUsage, err := j.source.WeekUsage(ctx)
+	if err != nil {
+		return err
+	}
+	result.RowsChecked = len(weekUsage)
+	if err := j.deliver("storing week usage", func() error { return j.storageCase.StoreWeekUsage(ctx, weekUsage) }); err != nil {
+		return fmt.Errorf("error storing week usage: %v", err)
+	}
+	return nil
+}
+
+// storeMonthReport 生成并保存月用量报表，返回月末预测
+// 对账和预测失败时仍然保存报表，错误在保存后返回
+func (j *usageJobs) storeMonthReport(ctx context.Context, result *internal.JobResult) ([]internal.ProjectForecast, error) {
+	monthUsage, err := j.checkCase.MonthUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result.RowsChecked = len(monthUsage)
+
+	var errs []error
+	// 按发票月统计时，同时生成按分区统计与按发票月统计的对账
+	reconciliation, err := j.checkCase.MonthReconciliation(ctx, monthUsage)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("error reconciling month usage: %v", err))
+	}
+
+	// 月末预测
+	var forecasts []internal.ProjectForecast
+	if j.cfg.Forecast.Enabled {
+		forecastCase, err := internal.NewForecastCase(j.source, j.cfg, j.calendar)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid forecast configuration: %v", err))
+		} else if forecasts, err = forecastCase.MonthForecast(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("error forecasting month usage: %v", err))
+		}
+	}
+
+	err = j.deliver("storing month usage", func() error {
+		return j.storageCase.StoreMonthUsage(ctx, monthUsage, forecasts, reconciliation)
+	})
+	if err != nil {
+		errs = append(errs, fmt.Errorf("error storing month usage: %v", err))
+	}
+	return forecasts, errors.Join(errs...)
+}
@@ -0,0 +1,112 @@
+package billingUsage
+
+import (
+	"clzrt.io/billingUsage/internal"
+	"clzrt.io/billingUsage/internal/config"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubNotifier 记录通知，err 不为空时发送失败
+type stubNotifier struct {
+	alerts []internal.Alert
+	err    error
+}
+
+func (n *stubNotifier) Notify(ctx context.Context, alert internal.Alert) error {
+	n.alerts = append(n.alerts, alert)
+	return n.err
+}
+
+func TestBudgetCheckRetriesFailedNotification(t *testing.T) {
+	ctx := context.Background()
+	calendar, err := internal.NewCalendar("UTC", "")
+	require.NoError(t, err)
+	calendar = calendar.WithClock(internal.FixedClock(time.Date(2024, 4, 10, 9, 0, 0, 0, time.UTC)))
+	var days []internal.ProjectDailyCost
+	for d := 1; d < 10; d++ {
+		days = append(days, internal.ProjectDailyCost{ProjectID: "web", Day: time.Date(2024, 4, d, 0, 0, 0, 0, time.UTC), Cost: 60, Currency: "USD"})
+	}
+	storageCase, err := internal.NewLocalStorageCase(t.TempDir(), calendar)
+	require.NoError(t, err)
+	notifier := &stubNotifier{err: errors.New("robot unavailable")}
+	jobs := &usageJobs{
+		cfg:         &config.Config{Budgets: []config.Budget{{Project: "web", Amount: 1000, Thresholds: []float64{50}}}},
+		calendar:    calendar,
+		source:      &internal.MemorySource{Days: days},
+		storageCase: storageCase,
+		notifier:    internal.Notifiers{notifier},
+	}
+
+	// 发送失败时不保存报警状态
+	var result internal.JobResult
+	require.Error(t, jobs.budgetCheck(ctx, &result))
+	assert.Equal(t, 1, result.Anomalies)
+	assert.Zero(t, result.Notifications)
+	require.Len(t, notifier.alerts, 1)
+
+	// 下次运行再次报警，发送成功后保存
+	notifier.err = nil
+	result = internal.JobResult{}
+	require.NoError(t, jobs.budgetCheck(ctx, &result))
+	assert.Equal(t, 1, result.Notifications)
+	require.Len(t, notifier.alerts, 2)
+	assert.Equal(t, []float64{50}, notifier.alerts[1].Budgets[0].Thresholds)
+
+	// 已报警的百分比不再报警
+	result = internal.JobResult{}
+	require.NoError(t, jobs.budgetCheck(ctx, &result))
+	assert.Len(t, notifier.alerts, 2)
+}
+
+func TestNotifyCountsDeliveredChannels(t *testing.T) {
+	ctx := context.Background()
+	alert := internal.Alert{Title: "daily Warning"}
+
+	// 没有配置渠道时不计数
+	var result internal.JobResult
+	jobs := &usageJobs{}
+	require.NoError(t, jobs.notify(ctx, &result, alert))
+	assert.Zero(t, result.Notifications)
+
+	// 只计发送成功的渠道
+	jobs.notifier = internal.Notifiers{&stubNotifier{}, &stubNotifier{err: errors.New("robot unavailable")}, &stubNotifier{}}
+	assert.Error(t, jobs.notify(ctx, &result, alert))
+	assert.Equal(t, 2, result.Notifications)
+}
+
+func TestWeekCheckStoresReport(t *testing.T) {
+	ctx := context.Background()
+	calendar, err := internal.NewCalendar("UTC", "")
+	require.NoError(t, err)
+	calendar = calendar.WithClock(internal.FixedClock(time.Date(2024, 3, 12, 9, 0, 0, 0, time.UTC)))
+	cfg := &config.Config{}
+	source := internal.NewMemorySource(nil, []internal.ProjectCostComparison{{ProjectID: "web", PreviousCost: 100, CurrentCost: 300, Delta: 200, DeltaPercent: 200}}, nil)
+	checkCase, err := internal.NewUsageCheckCase(source, cfg, calendar)
+	require.NoError(t, err)
+	outputDir := t.TempDir()
+	storageCase, err := internal.NewLocalStorageCase(outputDir, calendar)
+	require.NoError(t, err)
+	notifier := &stubNotifier{}
+	jobs := &usageJobs{cfg: cfg, calendar: calendar, source: source, checkCase: checkCase, storageCase: storageCase, notifier: internal.Notifiers{notifier}}
+
+	// 有异常时保存周用量报表，通知附报表链接(本地目录没有链接)
+	var result internal.JobResult
+	require.NoError(t, jobs.weekCheck(ctx, &result))
+	assert.Equal(t, 1, result.Anomalies)
+	assert.FileExists(t, filepath.Join(outputDir, "week_usage_2024-03-12.xlsx"))
+	require.Len(t, notifier.alerts, 1)
+	assert.Equal(t, storageCase.ReportURL("week"), notifier.alerts[0].ReportURL)
+
+	// 无异常时不保存报表
+	source.Week = []internal.ProjectCostComparison{{ProjectID: "web", PreviousCost: 100, CurrentCost: 100}}
+	jobs.storageCase = storageCase.WithClock(calendar.WithClock(internal.FixedClock(time.Date(2024, 3, 19, 9, 0, 0, 0, time.UTC))))
+	require.NoError(t, jobs.weekCheck(ctx, &internal.JobResult{}))
+	assert.NoFileExists(t, filepath.Join(outputDir, "week_usage_2024-03-19.xlsx"))
+}
@@ -4,137 +4,300 @@ import (
 	"clzrt.io/billingUsage/internal"
 	"clzrt.io/billingUsage/internal/config"
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/cloudevents/sdk-go/v2/event"
 	"log"
-	"time"
 )
 
 type MessagePublishedData struct {
 	Message PubSubMessage
 }
 type PubSubMessage struct {
+	// Data JSON 格式的 RunCommand
 	Data []byte `json:"data"`
 }
 
-func usageCheck() {
-	ctx := context.Background()
+// usageCheck clock 决定"今天"，执行命令指定的任务，未指定时执行 schedule 中到期的任务
+// 配置或依赖初始化失败时不执行任何任务，错误记录在返回结果中
+func usageCheck(ctx context.Context, clock internal.Clock, cmd RunCommand) *internal.RunResult {
+	result := &internal.RunResult{AsOf: cmd.AsOf, DryRun: cmd.DryRun}
 
 	// Load configuration from YAML file
-	loadConfig, err := config.LoadConfig("config_bk.yaml")
+	loadConfig, err := config.LoadConfig(cmd.configPath())
 	if err != nil {
-		log.Fatalf("failed to load loadConfig: %v", err)
+		result.AddError(fmt.Errorf("failed to load config: %v", err))
+		return result
+	}
+	if len(cmd.Recipients) > 0 {
+		loadConfig.Recipients = cmd.Recipients
 	}
 
-	// Initialize cases with configuration
-	bgUserCase := internal.NewBigQueryUserCase(loadConfig.BigQuery.ProjectID, ctx)
-	defer bgUserCase.Client.Close()
+	// 日/周/月边界按报表时区计算
+	calendar, err := reportCalendar(loadConfig, clock, cmd.AsOf)
+	if err != nil {
+		result.AddError(fmt.Errorf("invalid report calendar: %v", err))
+		return result
+	}
+	result.AsOf = calendar.Now().Format("2006-01-02")
+	jobNames := cmd.Jobs
+	if len(jobNames) == 0 {
+		schedule, err := internal.NewJobSchedule(loadConfig.Schedule, calendar)
+		if err != nil {
+			result.AddError(fmt.Errorf("invalid schedule: %v", err))
+			return result
+		}
+		jobNames = schedule.Due()
+		if cmd.AsOf != "" {
+			jobNames = schedule.DueOn()
+		}
+	}
+	log.Printf("running usage check as of %s, jobs: %v, dry run: %v", result.AsOf, jobNames, cmd.DryRun)
 
-	webHookUserCase := internal.NewWebHookUserCaseWithDingTalk(loadConfig.Webhook.URL)
-	storageCase, err := internal.NewStorageCase(ctx, loadConfig.Storage.Bucket, loadConfig.Storage.ProjectID)
+	// Initialize cases with configuration
+	source, closeSource, err := newBillingSource(ctx, loadConfig, calendar)
+	if err != nil {
+		result.AddError(fmt.Errorf("failed to create billing source: %v", err))
+		return result
+	}
+	defer closeSource()
+	checkCase, err := internal.NewUsageCheckCase(source, loadConfig, calendar)
+	if err != nil {
+		result.AddError(fmt.Errorf("failed to create usage checker: %v", err))
+		return result
+	}
+	storageCase, err := newStorageCase(ctx, loadConfig, cmd.OutputDir, calendar)
+	if err != nil {
+		result.AddError(err)
+		return result
+	}
 	defer storageCase.Close()
 
-	emailCase := internal.NewEmailUseCase(storageCase, loadConfig.Email.SMTPHost, loadConfig.Email.SMTPPort, loadConfig.Email.Username, loadConfig.Email.Password)
-	recipients := loadConfig.Recipients
+	notifier, err := internal.NewNotifierRegistry().FromConfig(loadConfig, cmd.renderDir())
+	if err != nil {
+		result.AddError(fmt.Errorf("invalid notifiers: %v", err))
+		return result
+	}
+	if cmd.render() {
+		storageCase = storageCase.WithDryRun(cmd.RenderDir)
+	}
+	emailCase := internal.NewEmailUseCase(storageCase, loadConfig.Email.SMTPHost, loadConfig.Email.SMTPPort, loadConfig.Email.Username, loadConfig.Email.Password).WithClock(calendar)
+	if cmd.render() {
+		emailCase = emailCase.WithDryRun(cmd.RenderDir)
+	}
 
-	dailyUsage, err := bgUserCase.DailyCheck(ctx)
+	jobs := &usageJobs{
+		cfg:         loadConfig,
+		calendar:    calendar,
+		source:      source,
+		checkCase:   checkCase,
+		storageCase: storageCase,
+		notifier:    notifier,
+		emailCase:   emailCase,
+		// 写入 RenderDir 时各任务照常执行，由各 case 负责不发送
+		dryRun: cmd.DryRun && !cmd.render(),
+	}
+	runner := internal.NewJobRunner()
+	jobs.register(runner)
+	result.Jobs = runner.Run(ctx, jobNames)
+	if cmd.render() {
+		logRendered(result, cmd.RenderDir)
+	}
+	return result
+}
+
+// logRendered 记录 dry run 写入的消息和邮件数量
+func logRendered(result *internal.RunResult, dir string) {
+	notifications := 0
+	for _, job := range result.Jobs {
+		notifications += job.Notifications
+	}
+	log.Printf("dry run: %d messages and emails rendered to %s", notifications, dir)
+}
+
+// backfill 对 [from, to] 中的每一天重新生成周/月报表和日用量明细报表，用于账单数据修正后覆盖历史报表，不发送通知和邮件
+// 每天的报表为一个任务结果，某天失败不影响其他日期
+func backfill(ctx context.Context, cmd RunCommand) *internal.RunResult {
+	from, to := cmd.BackfillFrom, cmd.BackfillTo
+	result := &internal.RunResult{DryRun: cmd.DryRun}
+
+	loadConfig, err := config.LoadConfig(cmd.configPath())
 	if err != nil {
-		log.Println(err)
+		result.AddError(fmt.Errorf("failed to load config: %v", err))
+		return result
 	}
-	// 日用量有异常才发送
-	if dailyUsage != nil {
-		webHookUserCase.Send2DingTalk(dailyUsage, "daily Warning")
-	} else {
-		webHookUserCase.Send2DingTalk(dailyUsage, "日用量无异常")
-		log.Println("日用量无异常")
+	calendar, err := internal.NewCalendar(loadConfig.Report.Timezone, loadConfig.Report.WeekStart)
+	if err != nil {
+		result.AddError(fmt.Errorf("invalid report calendar: %v", err))
+		return result
+	}
+	start, err := calendar.ParseDate(from)
+	if err != nil {
+		result.AddError(err)
+		return result
+	}
+	end, err := calendar.ParseDate(to)
+	if err != nil {
+		result.AddError(err)
+		return result
+	}
+	if end.Before(start) {
+		result.AddError(fmt.Errorf("invalid backfill range: %s is after %s", from, to))
+		return result
 	}
 
-	//周用量有异常 才发送
-	if isTodayTuesday() {
-		// 检查周用量数据异常
-		weekUsageCheck, err := bgUserCase.WeekCheck(ctx)
-		if err != nil {
-			return
-		}
-		if weekUsageCheck != nil {
-			webHookUserCase.Send2DingTalk(weekUsageCheck, "周用量异常")
-		} else {
-			webHookUserCase.Send2DingTalk(nil, "周用量无异常")
-			log.Println("周用量无异常")
-		}
+	storageCase, err := newStorageCase(ctx, loadConfig, cmd.OutputDir, calendar)
+	if err != nil {
+		result.AddError(err)
+		return result
+	}
+	defer storageCase.Close()
+	if cmd.render() {
+		storageCase = storageCase.WithDryRun(cmd.RenderDir)
+	}
 
+	// 所有日期共用同一个数据源，每天只替换统计周期的日期
+	source, closeSource, err := newBillingSource(ctx, loadConfig, calendar)
+	if err != nil {
+		result.AddError(fmt.Errorf("failed to create billing source: %v", err))
+		return result
+	}
+	defer closeSource()
+
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		asOf := day.Format("2006-01-02")
+		log.Printf("backfilling reports as of %s", asOf)
+		dayCalendar := calendar.WithClock(internal.FixedClock(day))
+		if err := backfillDay(ctx, loadConfig, dayCalendar, sourceAsOf(source, dayCalendar), storageCase, cmd.DryRun && !cmd.render(), result); err != nil {
+			result.AddError(fmt.Errorf("%s: %v", asOf, err))
+		}
 	}
+	return result
+}
 
-	// 月用量异常 发送
+// backfillDay 重新生成 calendar 当天的日/周/月报表，结果追加到 result，日报表只在开启明细且有异常时生成
+func backfillDay(ctx context.Context, cfg *config.Config, calendar *internal.Calendar, source internal.BillingSource, storageCase *internal.StorageCase, dryRun bool, result *internal.RunResult) error {
+	checkCase, err := internal.NewUsageCheckCase(source, cfg, calendar)
+	if err != nil {
+		return fmt.Errorf("failed to create usage checker: %v", err)
+	}
 
-	if isTodaySecond() {
-		monthUsageCheck, err := bgUserCase.MonthCheck(ctx)
+	jobs := &usageJobs{cfg: cfg, calendar: calendar, source: source, checkCase: checkCase, storageCase: storageCase.WithClock(calendar), dryRun: dryRun}
+	runner := internal.NewJobRunner()
+	runner.Register(internal.JobDailyCheck, func(ctx context.Context, result *internal.JobResult) error {
+		daily, err := checkCase.DailyCheck(ctx)
 		if err != nil {
-			return
-		}
-		if monthUsageCheck != nil {
-			webHookUserCase.Send2DingTalk(monthUsageCheck, "月用量异常")
-		} else {
-			webHookUserCase.Send2DingTalk(nil, "月用量无异常")
-			log.Println("月用量无异常")
+			return err
 		}
+		result.RowsChecked = daily.Checked
+		result.Anomalies = len(daily.Anomalies)
+		_, err = jobs.storeDailyReport(ctx, daily.Anomalies)
+		return err
+	})
+	runner.Register(internal.JobWeekReport, jobs.storeWeekReport)
+	runner.Register(internal.JobMonthReport, func(ctx context.Context, result *internal.JobResult) error {
+		_, err := jobs.storeMonthReport(ctx, result)
+		return err
+	})
+	asOf := calendar.Now().Format("2006-01-02")
+	for _, job := range runner.Run(ctx, []string{internal.JobDailyCheck, internal.JobWeekReport, internal.JobMonthReport}) {
+		job.AsOf = asOf
+		result.Jobs = append(result.Jobs, job)
+	}
+	return nil
+}
 
+// sourceAsOf 按 calendar 的日期计算统计周期的 source 副本，共用同一个 BigQuery client 或已加载的账单
+func sourceAsOf(source internal.BillingSource, calendar *internal.Calendar) internal.BillingSource {
+	switch s := source.(type) {
+	case *internal.BigQueryUserCase:
+		return s.WithClock(calendar)
+	case *internal.FileSource:
+		return s.WithCalendar(calendar)
 	}
+	return source
+}
 
-	// 每周一，检查 (上周用量,上上周）和（本月，上月）用量
-	// 判断当天 是否为周一，周一才统计周，月用量
-	if isTodayMonthDay() {
+// reportCalendar 报表时区的日历，asOf 不为空时固定在该日期
+func reportCalendar(cfg *config.Config, clock internal.Clock, asOf string) (*internal.Calendar, error) {
+	calendar, err := internal.NewCalendar(cfg.Report.Timezone, cfg.Report.WeekStart)
+	if err != nil {
+		return nil, err
+	}
+	if asOf != "" {
+		return calendar.AsOf(asOf)
+	}
+	return calendar.WithClock(clock), nil
+}
+
+// newStorageCase outputDir 不为空时使用本地目录，否则使用配置中的 Cloud Storage bucket
+func newStorageCase(ctx context.Context, cfg *config.Config, outputDir string, clock internal.Clock) (*internal.StorageCase, error) {
+	if outputDir != "" {
+		return internal.NewLocalStorageCase(outputDir, clock)
+	}
+	return internal.NewStorageCase(ctx, cfg.Storage.Bucket, cfg.Storage.ProjectID, clock)
+}
 
-		weekUsage, err := bgUserCase.WeekUsage(ctx)
+// newBillingSource 根据配置选择账单数据来源
+func newBillingSource(ctx context.Context, cfg *config.Config, calendar *internal.Calendar) (internal.BillingSource, func(), error) {
+	switch cfg.Source.Type {
+	case "", "bigquery":
+		bgUserCase, err := internal.NewBigQueryUserCase(cfg.BigQuery.ProjectID, ctx, cfg, calendar)
 		if err != nil {
-			log.Println(err)
+			return nil, nil, err
 		}
-		monthUsage, err := bgUserCase.MonthUsage(ctx)
+		return bgUserCase, func() { bgUserCase.Client.Close() }, nil
+	case "file":
+		dimension, err := internal.ParseDimension(cfg.Report.GroupBy)
 		if err != nil {
-			log.Println(err)
+			return nil, nil, err
 		}
-
-		// 存储周使用量数据
-		err = storageCase.StoreWeekUsage(ctx, weekUsage)
+		costMode, err := internal.ParseCostMode(cfg.Report.CostMode)
 		if err != nil {
-			log.Printf("error storing week usage: %v", err)
+			return nil, nil, err
 		}
-
-		// 存储月使用量数据
-		err = storageCase.StoreMonthUsage(ctx, monthUsage)
+		fileSource, err := internal.NewFileSource(dimension, cfg.Source.Files...)
 		if err != nil {
-			log.Printf("error storing month usage: %v", err)
-		}
-
-		for _, recipient := range recipients {
-			// 发送周使用量报告
-			err = emailCase.SendWeekUsageReport(ctx, recipient)
-			if err != nil {
-				log.Printf("Error sending week usage report: %v", err)
-				// 继续执行，不要因为发送邮件失败就中断整个流程
-			}
-
-			// 发送月使用量报告
-			err = emailCase.SendMonthUsageReport(ctx, recipient)
-			if err != nil {
-				log.Printf("Error sending month usage report: %v", err)
-				// 继续执行，不要因为发送邮件失败就中断整个流程
-			}
+			return nil, nil, err
 		}
+		return fileSource.WithCostMode(costMode).WithCalendar(calendar), func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown billing source type: %s", cfg.Source.Type)
 	}
 }
 
-func isTodayMonthDay() bool {
-	return time.Now().Weekday() == time.Monday
+// Run 执行命令，backfillFrom 不为空时重新生成报表，否则执行指定或到期的任务
+func Run(ctx context.Context, cmd RunCommand) *internal.RunResult {
+	if cmd.BackfillFrom != "" {
+		return backfill(ctx, cmd)
+	}
+	return usageCheck(ctx, internal.SystemClock, cmd)
 }
 
-func isTodaySecond() bool {
-	return time.Now().Day() == 2
+// SendTestNotification 向所有通知渠道发送一条测试消息，dry run 时只记录或写入 RenderDir
+func SendTestNotification(ctx context.Context, cmd RunCommand) error {
+	loadConfig, err := config.LoadConfig(cmd.configPath())
+	if err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+	notifier, err := internal.NewNotifierRegistry().FromConfig(loadConfig, cmd.renderDir())
+	if err != nil {
+		return fmt.Errorf("invalid notifiers: %v", err)
+	}
+	if cmd.DryRun && !cmd.render() {
+		log.Printf("dry run: skip sending test message to %d notifiers", len(notifier))
+		return nil
+	}
+	return notifier.Notify(ctx, internal.Alert{Title: "billingCheck 通知测试", Message: "billingCheck 测试消息"})
 }
 
-func isTodayTuesday() bool {
-
-	return time.Now().Weekday() == time.Tuesday
+// ValidateConfig 加载并校验配置文件，返回所有配置错误
+func ValidateConfig(path string) error {
+	loadConfig, err := config.LoadConfig(path)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+	return internal.ValidateConfig(loadConfig)
 }
 
 func DailyRun(ctx context.Context, e event.Event) error {
@@ -142,7 +305,14 @@ func DailyRun(ctx context.Context, e event.Event) error {
 	if err := e.DataAs(&msg); err != nil {
 		return fmt.Errorf("event.DataAs: %v", err)
 	}
-	usageCheck()
-	return nil
-
+	cmd, err := parseRunCommand(msg.Message.Data)
+	if err != nil {
+		return err
+	}
+	result := Run(ctx, cmd)
+	// 运行摘要写入日志，便于监控按任务统计
+	if summary, err := json.Marshal(result); err == nil {
+		log.Printf("run result: %s", summary)
+	}
+	return result.Err()
 }
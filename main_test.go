@@ -1,29 +1,123 @@
 package billingUsage
 
 import (
+	"clzrt.io/billingUsage/internal"
 	"context"
 	"github.com/cloudevents/sdk-go/v2/event"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-// 测试函数
+// writeTestConfig 在临时目录写入使用本地账单导出文件、上海时区的配置，extra 追加到配置末尾
+func writeTestConfig(t *testing.T, extra string) string {
+	export, err := filepath.Abs("internal/testdata/billing_export.csv")
+	require.NoError(t, err)
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(`
+source:
+  type: "file"
+  files: ["`+export+`"]
+report:
+  timezone: "Asia/Shanghai"
+`+extra), 0o644))
+	return configPath
+}
+
+// pubSubEvent Pub/Sub 触发的 Cloud Event，data 为 JSON 格式的 RunCommand
+func pubSubEvent(t *testing.T, data string) event.Event {
+	e := event.New()
+	require.NoError(t, e.SetData(event.ApplicationJSON, MessagePublishedData{Message: PubSubMessage{Data: []byte(data)}}))
+	return e
+}
+
 func TestDailyRun(t *testing.T) {
-	// 创建模拟上下文
 	ctx := context.Background()
+	// DailyRun 使用 Cloud Storage，指向不存在的模拟器以免需要凭据，dry run 时不会访问
+	t.Setenv("STORAGE_EMULATOR_HOST", "127.0.0.1:1")
+	// DailyRun 按 profile 读取当前目录下的 config_<profile>.yaml
+	configPath := writeTestConfig(t, "")
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(filepath.Dir(configPath)))
+	defer os.Chdir(wd)
+	require.NoError(t, os.Rename(configPath, "config_test.yaml"))
 
-	// 创建模拟的事件数据
-	msg := MessagePublishedData{}
+	err = DailyRun(ctx, pubSubEvent(t, `{"jobs": ["weekCheck", "monthCheck"], "asOf": "2024-03-12", "dryRun": true, "profile": "test"}`))
+	assert.NoError(t, err)
 
-	// 将模拟的数据转换为 Cloud Event 的 Data
-	e := event.New()
-	err := e.SetData(event.ApplicationJSON, msg)
-	assert.NoError(t, err, "failed to set event data")
+	// 配置不存在时返回加载配置的错误
+	err = DailyRun(ctx, pubSubEvent(t, `{"jobs": ["weekCheck"], "profile": "missing"}`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to load config")
+	assert.Contains(t, err.Error(), "config_missing.yaml")
+
+	// 命令无效时不执行任何任务
+	err = DailyRun(ctx, pubSubEvent(t, `{"jobs": ["weeklyCheck"], "profile": "test"}`))
+	assert.EqualError(t, err, `unknown job "weeklyCheck", expected one of [dailyCheck budgetCheck weekCheck monthCheck weekReport monthReport]`)
+}
+
+func TestUsageCheckDates(t *testing.T) {
+	cmd := RunCommand{ConfigPath: writeTestConfig(t, ""), OutputDir: t.TempDir(), DryRun: true}
+	run := func(utc time.Time) (string, []string) {
+		result := usageCheck(context.Background(), internal.FixedClock(utc), cmd)
+		require.NoError(t, result.Err())
+		var jobs []string
+		for _, job := range result.Jobs {
+			jobs = append(jobs, job.Job)
+		}
+		return result.AsOf, jobs
+	}
+
+	// UTC 周日 16:00 在上海已是周一，生成周/月报表
+	asOf, jobs := run(time.Date(2024, 3, 10, 16, 0, 0, 0, time.UTC))
+	assert.Equal(t, "2024-03-11", asOf)
+	assert.Equal(t, []string{internal.JobDailyCheck, internal.JobBudgetCheck, internal.JobWeekReport, internal.JobMonthReport}, jobs)
+
+	// 上海 4 月 2 日周二，检查周用量和月用量
+	asOf, jobs = run(time.Date(2024, 4, 1, 17, 0, 0, 0, time.UTC))
+	assert.Equal(t, "2024-04-02", asOf)
+	assert.Equal(t, []string{internal.JobDailyCheck, internal.JobBudgetCheck, internal.JobWeekCheck, internal.JobMonthCheck}, jobs)
+
+	// UTC 12/31 在上海已是 2024-01-01 周一，跨年当天照常生成周/月报表
+	asOf, jobs = run(time.Date(2023, 12, 31, 17, 0, 0, 0, time.UTC))
+	assert.Equal(t, "2024-01-01", asOf)
+	assert.Equal(t, []string{internal.JobDailyCheck, internal.JobBudgetCheck, internal.JobWeekReport, internal.JobMonthReport}, jobs)
+
+	// 指定 asOf 时不使用 clock
+	cmd.AsOf = "2024-03-12"
+	asOf, jobs = run(time.Date(2024, 3, 10, 16, 0, 0, 0, time.UTC))
+	assert.Equal(t, "2024-03-12", asOf)
+	assert.Equal(t, []string{internal.JobDailyCheck, internal.JobBudgetCheck, internal.JobWeekCheck}, jobs)
+
+	// 非零点的任务在 asOf 当天执行，不推迟到下一天
+	cmd.ConfigPath = writeTestConfig(t, "schedule:\n  weekCheck: \"0 9 * * tue\"\n")
+	_, jobs = run(time.Date(2024, 3, 10, 16, 0, 0, 0, time.UTC))
+	assert.Equal(t, []string{internal.JobDailyCheck, internal.JobBudgetCheck, internal.JobWeekCheck}, jobs)
+	cmd.AsOf = "2024-03-13"
+	_, jobs = run(time.Date(2024, 3, 10, 16, 0, 0, 0, time.UTC))
+	assert.Equal(t, []string{internal.JobDailyCheck, internal.JobBudgetCheck}, jobs)
+}
 
-	// 调用待测试的函数
-	err = DailyRun(ctx, e)
+func TestBackfill(t *testing.T) {
+	outputDir := t.TempDir()
+	cmd := RunCommand{
+		ConfigPath:   writeTestConfig(t, "drillDown:\n  enabled: true\n"),
+		OutputDir:    outputDir,
+		BackfillFrom: "2024-03-05",
+		BackfillTo:   "2024-03-06",
+	}
+	result := Run(context.Background(), cmd)
+	require.NoError(t, result.Err())
+	require.Len(t, result.Jobs, 6)
+	assert.Equal(t, internal.JobDailyCheck, result.Jobs[3].Job)
+	assert.Equal(t, "2024-03-06", result.Jobs[3].AsOf)
 
-	// 验证是否没有错误
-	assert.NoError(t, err, "DailyRun should not return an error")
+	for _, name := range []string{"daily_usage_2024-03-06.xlsx", "week_usage_2024-03-06.xlsx", "month_usage_2024-03-06.xlsx"} {
+		assert.FileExists(t, filepath.Join(outputDir, name))
+	}
 }
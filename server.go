@@ -0,0 +1,166 @@
+package billingUsage
+
+import (
+	"clzrt.io/billingUsage/internal"
+	"clzrt.io/billingUsage/internal/config"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// reportPeriods /reports/{period}/{date} 中可下载的报表
+var reportPeriods = map[string]bool{"week": true, "month": true, "daily": true}
+
+// maxRunRequestBytes POST /run/{job} 请求体的最大字节数
+const maxRunRequestBytes = 64 << 10
+
+// server HTTP 接口，与 Pub/Sub 触发执行相同的任务
+type server struct {
+	// base 每个请求的默认命令，决定配置文件和报表输出目录
+	base RunCommand
+	// token 不为空时 /run 和 /reports 需要 Authorization: Bearer <token>
+	token string
+}
+
+// NewHandler 返回 HTTP 接口，可部署到 Cloud Run 或在本地运行:
+//
+//	POST /run/{job}              立即执行任务，body 可选，为 RunCommand JSON(asOf、dryRun、recipients、profile)，返回 RunResult
+//	GET  /reports/{period}/{date} 下载 week/month/daily 报表，date 为 YYYY-MM-DD，可用 ?profile= 指定配置
+//	GET  /healthz                健康检查
+//
+// profile 对应 base 配置文件所在目录下的 config_<profile>.yaml。
+// token 为空时不校验身份，此时请求不能指定 recipients 和 profile，以免任意调用者将报表发送到其他邮箱或读取其他配置
+func NewHandler(base RunCommand, token string) http.Handler {
+	s := &server{base: base, token: token}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.healthz)
+	mux.HandleFunc("POST /run/{job}", s.authorized(s.run))
+	mux.HandleFunc("GET /reports/{period}/{date}", s.authorized(s.report))
+	return mux
+}
+
+// authorized 设置了 token 时校验请求的 Bearer token
+func (s *server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			want := []byte("Bearer " + s.token)
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+func (s *server) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// run 执行一个任务，任务失败时返回 500，body 仍为 RunResult
+func (s *server) run(w http.ResponseWriter, r *http.Request) {
+	job := r.PathValue("job")
+	if !internal.IsJob(job) {
+		http.Error(w, fmt.Sprintf("unknown job %q, expected one of %v", job, internal.Jobs), http.StatusNotFound)
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRunRequestBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error reading request: %v", err), http.StatusBadRequest)
+		return
+	}
+	cmd, err := parseRunCommand(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(cmd.Jobs) > 0 || cmd.BackfillFrom != "" {
+		http.Error(w, "jobs and backfill are not supported, the job is given by the path", http.StatusBadRequest)
+		return
+	}
+	if s.token == "" && (len(cmd.Recipients) > 0 || cmd.Profile != "") {
+		http.Error(w, "recipients and profile require the server to be started with an auth token", http.StatusForbidden)
+		return
+	}
+	cmd.Jobs = []string{job}
+	base := s.base.withProfile(cmd.Profile)
+	cmd.Profile, cmd.ConfigPath, cmd.OutputDir, cmd.RenderDir = base.Profile, base.ConfigPath, base.OutputDir, base.RenderDir
+	cmd.DryRun = cmd.DryRun || base.DryRun
+
+	result := Run(r.Context(), cmd)
+	status := http.StatusOK
+	if err := result.Err(); err != nil {
+		log.Printf("run %s failed: %v", job, err)
+		status = http.StatusInternalServerError
+	}
+	writeJSON(w, status, result)
+}
+
+// report 下载已生成的报表
+func (s *server) report(w http.ResponseWriter, r *http.Request) {
+	kind := r.PathValue("period")
+	if !reportPeriods[kind] {
+		http.Error(w, fmt.Sprintf("unknown report period %q, expected week, month or daily", kind), http.StatusNotFound)
+		return
+	}
+	date := r.PathValue("date")
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		http.Error(w, fmt.Sprintf("invalid date %q, expected YYYY-MM-DD", date), http.StatusBadRequest)
+		return
+	}
+
+	profile := r.URL.Query().Get("profile")
+	if profile != "" && !profilePattern.MatchString(profile) {
+		http.Error(w, fmt.Sprintf("invalid config profile %q", profile), http.StatusBadRequest)
+		return
+	}
+	if s.token == "" && profile != "" {
+		http.Error(w, "profile requires the server to be started with an auth token", http.StatusForbidden)
+		return
+	}
+
+	loadConfig, err := config.LoadConfig(s.base.withProfile(profile).configPath())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to load config: %v", err), http.StatusInternalServerError)
+		return
+	}
+	storageCase, err := newStorageCase(r.Context(), loadConfig, s.base.OutputDir, internal.SystemClock)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer storageCase.Close()
+
+	content, err := storageCase.GetReport(r.Context(), kind, date)
+	if errors.Is(err, internal.ErrReportNotExist) {
+		http.Error(w, fmt.Sprintf("%s report for %s does not exist", kind, date), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", kind+"_usage_"+date+".xlsx"))
+	w.Write(content)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error writing response: %v", err)
+	}
+}
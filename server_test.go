@@ -0,0 +1,94 @@
+package billingUsage
+
+import (
+	"clzrt.io/billingUsage/internal"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	handler := NewHandler(RunCommand{ConfigPath: writeTestConfig(t, ""), OutputDir: filepath.Join(t.TempDir(), "reports")}, "")
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return recorder
+	}
+
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/healthz", "").Code)
+
+	// 没有收件人时只保存报表
+	resp := do(http.MethodPost, "/run/weekReport", `{"asOf": "2024-03-11"}`)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	var result internal.RunResult
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+	assert.Equal(t, "2024-03-11", result.AsOf)
+	require.Len(t, result.Jobs, 1)
+	assert.Equal(t, internal.JobWeekReport, result.Jobs[0].Job)
+	assert.Equal(t, internal.JobSucceeded, result.Jobs[0].Status)
+
+	resp = do(http.MethodGet, "/reports/week/2024-03-11", "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NotEmpty(t, resp.Body.Bytes())
+	assert.Contains(t, resp.Header().Get("Content-Disposition"), "week_usage_2024-03-11.xlsx")
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/reports/month/2024-03-11", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/reports/year/2024-03-11", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/reports/week/20240311", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/run/weeklyReport", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/run/weekReport", `{"jobs": ["monthReport"]}`).Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, do(http.MethodGet, "/run/weekReport", "").Code)
+
+	// 没有设置 token 时不能指定收件人和配置
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/run/weekReport", `{"recipients": ["someone@example.com"]}`).Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/run/weekReport", `{"profile": "prod"}`).Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/reports/week/2024-03-11?profile=prod", "").Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, do(http.MethodPost, "/run/weekReport", `{"asOf": "`+strings.Repeat(" ", maxRunRequestBytes)+`"}`).Code)
+}
+
+func TestHandlerToken(t *testing.T) {
+	configPath := writeTestConfig(t, "")
+	// profile 对应同一目录下的 config_<profile>.yaml，这里的时区无效，加载后任务失败
+	require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(configPath), "config_prod.yaml"), []byte(`
+source:
+  type: "file"
+  files: ["unused.csv"]
+report:
+  timezone: "Mars/Olympus"
+`), 0o644))
+	handler := NewHandler(RunCommand{ConfigPath: configPath, OutputDir: filepath.Join(t.TempDir(), "reports")}, "secret")
+	do := func(method, target, token, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/healthz", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/run/weekReport", "", `{"asOf": "2024-03-11"}`).Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/run/weekReport", "wrong", `{"asOf": "2024-03-11"}`).Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/reports/week/2024-03-11", "", "").Code)
+
+	// 校验通过后可以指定收件人，dry run 不发送邮件
+	resp := do(http.MethodPost, "/run/weekReport", "secret", `{"asOf": "2024-03-11", "dryRun": true, "recipients": ["finance@example.com"]}`)
+	assert.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	// 指定 profile 时加载 config_prod.yaml
+	resp = do(http.MethodPost, "/run/weekReport", "secret", `{"asOf": "2024-03-11", "profile": "prod"}`)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	assert.Contains(t, resp.Body.String(), "Mars/Olympus")
+	resp = do(http.MethodGet, "/reports/week/2024-03-11?profile=missing", "secret", "")
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	assert.Contains(t, resp.Body.String(), filepath.Join(filepath.Dir(configPath), "config_missing.yaml"))
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/reports/week/2024-03-11?profile=../secrets", "secret", "").Code)
+}